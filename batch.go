@@ -0,0 +1,279 @@
+package storage_go
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultConcurrency = 4
+	// deleteBatchSize is the most keys a single delete request accepts
+	deleteBatchSize = 1000
+	// signBatchSize bounds the paths signed per request, keeping request bodies small
+	signBatchSize = 1000
+)
+
+// UploadItem is a single file of an UploadFiles batch
+type UploadItem struct {
+	Path string
+	Data io.Reader
+}
+
+// FileUploadResult is the outcome of one operation of a batch, Err is set when it failed
+type FileUploadResult struct {
+	Path     string
+	Response *FileUploadResponse
+	Err      error
+}
+
+// UploadFiles uploads the files with up to concurrency uploads in flight. A failed upload doesn't stop
+// the others, its error is reported in the matching result. Results are in the order of files.
+func (c *Client) UploadFiles(bucketId string, files []UploadItem, concurrency int) ([]FileUploadResult, error) {
+	return c.UploadFilesWithContext(context.Background(), bucketId, files, concurrency)
+}
+
+// UploadFilesWithContext is UploadFiles with cancellation, uploads not started when ctx is done
+// report ctx.Err() and the batch returns it too
+func (c *Client) UploadFilesWithContext(ctx context.Context, bucketId string, files []UploadItem, concurrency int) ([]FileUploadResult, error) {
+	results := make([]FileUploadResult, len(files))
+	runBatch(len(files), concurrency, func(i int) {
+		results[i].Path = files[i].Path
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			return
+		}
+		results[i].Response, results[i].Err = c.UploadFileWithContext(ctx, bucketId, files[i].Path, files[i].Data)
+	})
+
+	return results, ctx.Err()
+}
+
+// MovePair is a single move of a MoveFiles batch
+type MovePair struct {
+	SourceKey      string
+	DestinationKey string
+}
+
+// MoveFiles moves the objects within the bucket, running up to 4 moves at a time. A failed move doesn't
+// stop the others, its error is reported in the matching result whose Path is the source key.
+func (c *Client) MoveFiles(bucketId string, moves []MovePair) ([]FileUploadResult, error) {
+	return c.MoveFilesWithContext(context.Background(), bucketId, moves)
+}
+
+func (c *Client) MoveFilesWithContext(ctx context.Context, bucketId string, moves []MovePair) ([]FileUploadResult, error) {
+	results := make([]FileUploadResult, len(moves))
+	runBatch(len(moves), defaultConcurrency, func(i int) {
+		results[i].Path = moves[i].SourceKey
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			return
+		}
+		results[i].Response, results[i].Err = c.MoveFileWithContext(ctx, bucketId, moves[i].SourceKey, moves[i].DestinationKey)
+	})
+
+	return results, ctx.Err()
+}
+
+// DeleteOptions configures DeleteByPrefixWithOptions and RemoveFileWithOptions
+type DeleteOptions struct {
+	// DryRun only lists the objects the delete would remove, nothing is deleted
+	DryRun bool
+}
+
+// DeleteByPrefix deletes every object under the folder prefix, including its sub folders, and returns
+// how many objects were deleted. An empty prefix is rejected rather than emptying the whole bucket,
+// use EmptyBucket for that.
+func (c *Client) DeleteByPrefix(bucketId string, prefix string) (int, error) {
+	return c.DeleteByPrefixWithContext(context.Background(), bucketId, prefix)
+}
+
+func (c *Client) DeleteByPrefixWithContext(ctx context.Context, bucketId string, prefix string) (int, error) {
+	keys, err := c.DeleteByPrefixWithOptionsWithContext(ctx, bucketId, prefix, DeleteOptions{})
+	return len(keys), err
+}
+
+// DeleteByPrefixWithOptions is DeleteByPrefix returning the keys of the deleted objects, or with
+// DryRun the keys it would delete. When a batch fails the keys deleted until then are returned.
+func (c *Client) DeleteByPrefixWithOptions(bucketId string, prefix string, options DeleteOptions) ([]string, error) {
+	return c.DeleteByPrefixWithOptionsWithContext(context.Background(), bucketId, prefix, options)
+}
+
+func (c *Client) DeleteByPrefixWithOptionsWithContext(ctx context.Context, bucketId string, prefix string, options DeleteOptions) ([]string, error) {
+	prefix = strings.Trim(removeEmptyFolderName(prefix), "/")
+	if prefix == "" {
+		return nil, errors.New("storage: DeleteByPrefix needs a non-empty prefix")
+	}
+
+	keys, err := c.listKeys(ctx, bucketId, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if options.DryRun {
+		return keys, nil
+	}
+
+	var deleted []string
+	for start := 0; start < len(keys); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		objects, err := c.DeleteFilesWithContext(ctx, bucketId, keys[start:end])
+		if err != nil {
+			return deleted, err
+		}
+		for _, object := range objects {
+			deleted = append(deleted, object.Name)
+		}
+	}
+
+	return deleted, nil
+}
+
+// CreateSignedUrlsForPrefix signs every object under the folder prefix, including its sub folders, for
+// expiresIn seconds. An empty prefix signs the whole bucket.
+func (c *Client) CreateSignedUrlsForPrefix(bucketId string, prefix string, expiresIn int) ([]SignedUrlResponse, error) {
+	return c.CreateSignedUrlsForPrefixWithContext(context.Background(), bucketId, prefix, expiresIn)
+}
+
+func (c *Client) CreateSignedUrlsForPrefixWithContext(ctx context.Context, bucketId string, prefix string, expiresIn int) ([]SignedUrlResponse, error) {
+	// Checked before listing, which could take many requests
+	if err := validateExpiresIn(expiresIn); err != nil {
+		return nil, err
+	}
+
+	keys, err := c.listKeys(ctx, bucketId, strings.Trim(removeEmptyFolderName(prefix), "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	var signed []SignedUrlResponse
+	for start := 0; start < len(keys); start += signBatchSize {
+		end := start + signBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		urls, err := c.CreateSignedUrlsWithContext(ctx, bucketId, keys[start:end], expiresIn)
+		if err != nil {
+			return nil, err
+		}
+		signed = append(signed, urls...)
+	}
+
+	return signed, nil
+}
+
+// listKeys returns the keys of every object under folder, walking its sub folders
+func (c *Client) listKeys(ctx context.Context, bucketId string, folder string) ([]string, error) {
+	var keys []string
+	err := c.walkObjects(ctx, bucketId, folder, func(key string, file FileObject) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// walkObjects calls fn with the key and listing entry of every object under folder, recursing into
+// its sub folders. An empty folder walks the whole bucket.
+func (c *Client) walkObjects(ctx context.Context, bucketId string, folder string, fn func(key string, file FileObject) error) error {
+	files, err := c.ListFilesAllWithContext(ctx, bucketId, folder, FileSearchOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		key := file.Name
+		if folder != "" {
+			key = folder + "/" + file.Name
+		}
+		// Folders are listed as entries without an id
+		if file.Id != "" {
+			err = fn(key, file)
+		} else {
+			err = c.walkObjects(ctx, bucketId, key, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runBatch calls fn for every index in [0, n) from up to concurrency goroutines
+func runBatch(n int, concurrency int, fn func(i int)) {
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// findObjects lists the objects among keys which exist, named by their key like the objects a delete
+// returns. Each parent folder is listed once.
+func (c *Client) findObjects(ctx context.Context, bucketId string, keys []string) ([]FileObject, error) {
+	folders := map[string]map[string]FileObject{}
+	for _, key := range keys {
+		folder := parentFolder(key)
+		if _, ok := folders[folder]; ok {
+			continue
+		}
+
+		files, err := c.ListFilesAllWithContext(ctx, bucketId, folder, FileSearchOptions{})
+		if err != nil {
+			return nil, err
+		}
+		objects := make(map[string]FileObject, len(files))
+		for _, file := range files {
+			// Folders are listed as entries without an id
+			if file.Id != "" {
+				objects[file.Name] = file
+			}
+		}
+		folders[folder] = objects
+	}
+
+	var found []FileObject
+	for _, key := range keys {
+		folder := parentFolder(key)
+		if object, ok := folders[folder][path.Base(key)]; ok {
+			object.Name = key
+			found = append(found, object)
+		}
+	}
+
+	return found, nil
+}
+
+// parentFolder returns the folder holding key, empty for the bucket root
+func parentFolder(key string) string {
+	if folder := path.Dir(key); folder != "." {
+		return folder
+	}
+
+	return ""
+}
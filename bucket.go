@@ -2,172 +2,297 @@ package storage_go
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
-func (c *Client) ListBuckets() ([]Bucket, BucketResponseError) {
-	res, err := c.session.Get(c.clientTransport.baseUrl.String() + "/bucket")
+func (c *Client) ListBuckets() ([]Bucket, error) {
+	return c.ListBucketsWithContext(context.Background())
+}
+
+func (c *Client) ListBucketsWithContext(ctx context.Context) ([]Bucket, error) {
+	request, err := c.newRequest(ctx, http.MethodGet, "/bucket", nil)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			panic(err)
-		}
-	}(res.Body)
+	res, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+
+	if !isSuccess(res) {
+		return nil, parseErrorResponse(res)
+	}
 
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	var data []Bucket
-	err = json.Unmarshal(body, &data)
+	err = decodeJSON(body, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// ListBucketsWithPrefix lists the buckets whose ID starts with prefix, e.g. "tenant_42_".
+// The API has no bucket filter, so all buckets are fetched and filtered client side.
+func (c *Client) ListBucketsWithPrefix(prefix string) ([]Bucket, error) {
+	return c.ListBucketsWithPrefixWithContext(context.Background(), prefix)
+}
 
-	var respError BucketResponseError
-	err = json.Unmarshal(body, &respError)
+func (c *Client) ListBucketsWithPrefixWithContext(ctx context.Context, prefix string) ([]Bucket, error) {
+	return c.ListBucketsFilteredWithContext(ctx, func(bucket Bucket) bool {
+		return strings.HasPrefix(bucket.Id, prefix)
+	})
+}
 
-	return data, respError
+// ListBucketsFiltered lists the buckets for which keep returns true, filtering client side
+func (c *Client) ListBucketsFiltered(keep func(Bucket) bool) ([]Bucket, error) {
+	return c.ListBucketsFilteredWithContext(context.Background(), keep)
 }
 
-func (c *Client) GetBucket(id string) (Bucket, BucketResponseError) {
-	res, err := c.session.Get(c.clientTransport.baseUrl.String() + "/bucket/" + id)
+func (c *Client) ListBucketsFilteredWithContext(ctx context.Context, keep func(Bucket) bool) ([]Bucket, error) {
+	buckets, err := c.ListBucketsWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]Bucket, 0, len(buckets))
+	for _, bucket := range buckets {
+		if keep(bucket) {
+			filtered = append(filtered, bucket)
+		}
+	}
+
+	return filtered, nil
+}
+
+func (c *Client) GetBucket(id string) (*Bucket, error) {
+	return c.GetBucketWithContext(context.Background(), id)
+}
+
+func (c *Client) GetBucketWithContext(ctx context.Context, id string) (*Bucket, error) {
+	request, err := c.newRequest(ctx, http.MethodGet, "/bucket/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.do(request)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+
+	if !isSuccess(res) {
+		return nil, parseErrorResponse(res)
 	}
 
 	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
 	var data Bucket
-	var error_ BucketResponseError
-	err = json.Unmarshal(body, &data)
-	err = json.Unmarshal(body, &error_)
+	err = decodeJSON(body, &data)
+	if err != nil {
+		return nil, err
+	}
 
-	return data, error_
+	return &data, nil
 }
 
-func (c *Client) CreateBucket(id string, options BucketOptions) (Bucket, BucketResponseError) {
-	bodyData := map[string]interface{}{
-		"id":     id,
-		"name":   id,
-		"public": options.Public,
+func (c *Client) CreateBucket(id string, options BucketOptions) (*Bucket, error) {
+	return c.CreateBucketWithContext(context.Background(), id, options)
+}
+
+func (c *Client) CreateBucketWithContext(ctx context.Context, id string, options BucketOptions) (*Bucket, error) {
+	jsonBody, _ := json.Marshal(bucketRequestBody(id, options))
+	request, err := c.newRequest(ctx, http.MethodPost, "/bucket", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, err
 	}
-	// We only set the file size limit if it's not empty
-	if len(options.FileSizeLimit) > 0 {
-		bodyData["file_size_limit"] = options.FileSizeLimit
+	request.Header.Set("Content-Type", "application/json")
+
+	res, err := c.do(request)
+	if err != nil {
+		return nil, err
 	}
-	// We only set the allowed mime types if it's not empty
-	if len(options.AllowedMimeTypes) > 0 {
-		bodyData["allowed_mime_types"] = options.AllowedMimeTypes
+	defer drainAndClose(res.Body)
+
+	if !isSuccess(res) {
+		return nil, parseErrorResponse(res)
 	}
-	jsonBody, _ := json.Marshal(bodyData)
-	res, err := c.session.Post(c.clientTransport.baseUrl.String()+"/bucket",
-		"application/json",
-		bytes.NewBuffer(jsonBody))
+
+	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
+	// The API only answers with the bucket name, so fill in the rest from what was requested
 	var data Bucket
-	var error_ BucketResponseError
-	err = json.Unmarshal(body, &data)
+	err = decodeJSON(body, &data)
+	if err != nil {
+		return nil, err
+	}
+	data.Id = id
 	data.Public = options.Public
-	err = json.Unmarshal(body, &error_)
+	if options.FileSizeLimit != nil {
+		limit := int64(*options.FileSizeLimit)
+		data.FileSizeLimit = &limit
+	}
+	data.AllowedMimeTypes = options.AllowedMimeTypes
 
-	return data, error_
+	return &data, nil
 }
 
-func (c *Client) UpdateBucket(id string, options BucketOptions) (MessageResponse, BucketResponseError) {
-	bodyData := map[string]interface{}{
-		"id":     id,
-		"name":   id,
-		"public": options.Public,
-	}
-	// We only set the file size limit if it's not empty
-	if len(options.FileSizeLimit) > 0 {
-		bodyData["file_size_limit"] = options.FileSizeLimit
-	}
-	// We only set the allowed mime types if it's not empty
-	if len(options.AllowedMimeTypes) > 0 {
-		bodyData["allowed_mime_types"] = options.AllowedMimeTypes
+func (c *Client) UpdateBucket(id string, options BucketOptions) (*Bucket, error) {
+	return c.UpdateBucketWithContext(context.Background(), id, options)
+}
+
+func (c *Client) UpdateBucketWithContext(ctx context.Context, id string, options BucketOptions) (*Bucket, error) {
+	jsonBody, _ := json.Marshal(bucketRequestBody(id, options))
+	request, err := c.newRequest(ctx, http.MethodPut, "/bucket/"+url.PathEscape(id), bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, err
 	}
-	jsonBody, _ := json.Marshal(bodyData)
-	request, err := http.NewRequest(http.MethodPut, c.clientTransport.baseUrl.String()+"/bucket/"+id, bytes.NewBuffer(jsonBody))
-	res, err := c.session.Do(request)
+
+	res, err := c.do(request)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	defer drainAndClose(res.Body)
 
-	body, err := ioutil.ReadAll(res.Body)
-	var data MessageResponse
-	var error_ BucketResponseError
-	err = json.Unmarshal(body, &data)
-	err = json.Unmarshal(body, &error_)
+	if !isSuccess(res) {
+		return nil, parseErrorResponse(res)
+	}
+
+	// The update endpoint only answers with a message, so fetch the bucket as it is now stored
+	return c.GetBucketWithContext(ctx, id)
+}
 
-	return data, error_
+// EmptyBucket deletes every object in the bucket while keeping the bucket and its configuration
+func (c *Client) EmptyBucket(id string) error {
+	return c.EmptyBucketWithContext(context.Background(), id)
 }
 
-func (c *Client) EmptyBucket(id string) (MessageResponse, BucketResponseError) {
+func (c *Client) EmptyBucketWithContext(ctx context.Context, id string) error {
 	jsonBody, _ := json.Marshal(map[string]interface{}{})
-	res, err := c.session.Post(c.clientTransport.baseUrl.String()+"/bucket/"+id+"/empty", "application/json", bytes.NewBuffer(jsonBody))
+	request, err := c.newRequest(ctx, http.MethodPost, "/bucket/"+url.PathEscape(id)+"/empty", bytes.NewBuffer(jsonBody))
 	if err != nil {
-		panic(err)
+		return err
 	}
+	request.Header.Set("Content-Type", "application/json")
 
-	body, err := ioutil.ReadAll(res.Body)
-	var data MessageResponse
-	var error_ BucketResponseError
-	err = json.Unmarshal(body, &data)
-	err = json.Unmarshal(body, &error_)
+	res, err := c.do(request)
+	if err != nil {
+		return err
+	}
+	defer drainAndClose(res.Body)
 
-	return data, error_
+	if !isSuccess(res) {
+		return parseErrorResponse(res)
+	}
+
+	return nil
 }
 
-func (c *Client) DeleteBucket(id string) (MessageResponse, BucketResponseError) {
+func (c *Client) DeleteBucket(id string) error {
+	return c.DeleteBucketWithContext(context.Background(), id)
+}
+
+func (c *Client) DeleteBucketWithContext(ctx context.Context, id string) error {
 	jsonBody, _ := json.Marshal(map[string]interface{}{})
-	request, err := http.NewRequest(http.MethodDelete, c.clientTransport.baseUrl.String()+"/bucket/"+id, bytes.NewBuffer(jsonBody))
-	res, err := c.session.Do(request)
+	request, err := c.newRequest(ctx, http.MethodDelete, "/bucket/"+url.PathEscape(id), bytes.NewBuffer(jsonBody))
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
-	var data MessageResponse
-	var error_ BucketResponseError
-	err = json.Unmarshal(body, &data)
-	err = json.Unmarshal(body, &error_)
+	res, err := c.do(request)
+	if err != nil {
+		return err
+	}
+	defer drainAndClose(res.Body)
 
-	return data, error_
+	if !isSuccess(res) {
+		return parseErrorResponse(res)
+	}
+
+	return nil
 }
 
-type MessageResponse struct {
-	Message string `json:"message"`
+// GetBucketUsage counts the objects of the bucket and sums their sizes. The API has no usage endpoint,
+// so the whole bucket is listed: expect a request per folder and per 100 objects.
+func (c *Client) GetBucketUsage(bucketId string) (*BucketUsage, error) {
+	return c.GetBucketUsageWithContext(context.Background(), bucketId)
 }
 
-type BucketResponseError struct {
-	Error      string `json:"error"`
-	Message    string `json:"message"`
-	StatusCode uint16 `json:"statusCode"`
+func (c *Client) GetBucketUsageWithContext(ctx context.Context, bucketId string) (*BucketUsage, error) {
+	var usage BucketUsage
+	err := c.walkObjects(ctx, bucketId, "", func(key string, file FileObject) error {
+		usage.ObjectCount++
+		usage.TotalSize += file.Metadata.Size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &usage, nil
 }
 
+// bucketRequestBody builds the JSON body shared by the create and update bucket endpoints
+func bucketRequestBody(id string, options BucketOptions) map[string]interface{} {
+	bodyData := map[string]interface{}{
+		"id":     id,
+		"name":   id,
+		"public": options.Public,
+	}
+	// We only set the file size limit if it's not empty
+	if options.FileSizeLimit != nil {
+		bodyData["file_size_limit"] = *options.FileSizeLimit
+	}
+	// We only set the allowed mime types if it's not empty
+	if len(options.AllowedMimeTypes) > 0 {
+		bodyData["allowed_mime_types"] = options.AllowedMimeTypes
+	}
+
+	return bodyData
+}
+
+// Bucket is a storage bucket as returned by the API
 type Bucket struct {
-	Id               string   `json:"id"`
-	Name             string   `json:"name"`
-	Owner            string   `json:"owner"`
-	Public           bool     `json:"public"`
-	FileSizeLimit    string   `json:"file_size_limit"`
-	AllowedMimeTypes []string `json:"allowed_mine_types"`
+	Id     string `json:"id"`
+	Name   string `json:"name"`
+	Owner  string `json:"owner"`
+	Public bool   `json:"public"`
+	// FileSizeLimit is the maximum object size in bytes, nil when the bucket has no limit
+	FileSizeLimit *int64 `json:"file_size_limit"`
+	// AllowedMimeTypes is empty when the bucket accepts any mime type
+	AllowedMimeTypes []string `json:"allowed_mime_types"`
 	CreatedAt        string   `json:"created_at"`
 	UpdatedAt        string   `json:"updated_at"`
 }
 
+// BucketUsage is the storage a bucket consumes, see GetBucketUsage
+type BucketUsage struct {
+	ObjectCount int64
+	// TotalSize is the sum of the object sizes in bytes
+	TotalSize int64
+}
+
 type BucketOptions struct {
-	Public           bool
-	FileSizeLimit    string
+	Public bool
+	// FileSizeLimit is the maximum object size in bytes, nil leaves it unset
+	FileSizeLimit    *int
 	AllowedMimeTypes []string
 }
@@ -0,0 +1,140 @@
+package storage_go
+
+import (
+	"context"
+	"io"
+)
+
+// BucketClient is a Client bound to one bucket, its methods forward to the Client methods of the
+// same purpose with the bucket ID filled in
+type BucketClient struct {
+	client *Client
+	id     string
+}
+
+// Bucket returns a BucketClient for the bucket with the given ID, it shares the client's
+// configuration and connections
+func (c *Client) Bucket(bucketId string) *BucketClient {
+	return &BucketClient{client: c, id: bucketId}
+}
+
+// Id returns the ID of the bucket the client is bound to
+func (b *BucketClient) Id() string {
+	return b.id
+}
+
+func (b *BucketClient) Upload(path string, data io.Reader) (*FileUploadResponse, error) {
+	return b.UploadWithContext(context.Background(), path, data)
+}
+
+func (b *BucketClient) UploadWithContext(ctx context.Context, path string, data io.Reader) (*FileUploadResponse, error) {
+	return b.client.UploadFileWithContext(ctx, b.id, path, data)
+}
+
+func (b *BucketClient) UploadWithOptions(path string, data io.Reader, options FileOptions) (*FileUploadResponse, error) {
+	return b.UploadWithOptionsWithContext(context.Background(), path, data, options)
+}
+
+func (b *BucketClient) UploadWithOptionsWithContext(ctx context.Context, path string, data io.Reader, options FileOptions) (*FileUploadResponse, error) {
+	return b.client.UploadFileWithOptionsWithContext(ctx, b.id, path, data, options)
+}
+
+func (b *BucketClient) Update(path string, data io.Reader) (*FileUploadResponse, error) {
+	return b.UpdateWithContext(context.Background(), path, data)
+}
+
+func (b *BucketClient) UpdateWithContext(ctx context.Context, path string, data io.Reader) (*FileUploadResponse, error) {
+	return b.client.UpdateFileWithContext(ctx, b.id, path, data)
+}
+
+func (b *BucketClient) Download(path string) ([]byte, error) {
+	return b.DownloadWithContext(context.Background(), path)
+}
+
+func (b *BucketClient) DownloadWithContext(ctx context.Context, path string) ([]byte, error) {
+	return b.client.DownloadFileWithContext(ctx, b.id, path)
+}
+
+func (b *BucketClient) DownloadStream(path string) (*DownloadResult, error) {
+	return b.DownloadStreamWithContext(context.Background(), path)
+}
+
+func (b *BucketClient) DownloadStreamWithContext(ctx context.Context, path string) (*DownloadResult, error) {
+	return b.client.DownloadFileStreamWithContext(ctx, b.id, path)
+}
+
+func (b *BucketClient) List(prefix string, options FileSearchOptions) ([]FileObject, error) {
+	return b.ListWithContext(context.Background(), prefix, options)
+}
+
+func (b *BucketClient) ListWithContext(ctx context.Context, prefix string, options FileSearchOptions) ([]FileObject, error) {
+	return b.client.ListFilesWithContext(ctx, b.id, prefix, options)
+}
+
+func (b *BucketClient) ListAll(prefix string, options FileSearchOptions) ([]FileObject, error) {
+	return b.ListAllWithContext(context.Background(), prefix, options)
+}
+
+func (b *BucketClient) ListAllWithContext(ctx context.Context, prefix string, options FileSearchOptions) ([]FileObject, error) {
+	return b.client.ListFilesAllWithContext(ctx, b.id, prefix, options)
+}
+
+func (b *BucketClient) Remove(paths []string) ([]FileObject, error) {
+	return b.RemoveWithContext(context.Background(), paths)
+}
+
+func (b *BucketClient) RemoveWithContext(ctx context.Context, paths []string) ([]FileObject, error) {
+	return b.client.RemoveFileWithContext(ctx, b.id, paths)
+}
+
+func (b *BucketClient) Move(sourceKey string, destinationKey string) (*FileUploadResponse, error) {
+	return b.MoveWithContext(context.Background(), sourceKey, destinationKey)
+}
+
+func (b *BucketClient) MoveWithContext(ctx context.Context, sourceKey string, destinationKey string) (*FileUploadResponse, error) {
+	return b.client.MoveFileWithContext(ctx, b.id, sourceKey, destinationKey)
+}
+
+func (b *BucketClient) Copy(sourceKey string, destinationKey string) (*FileUploadResponse, error) {
+	return b.CopyWithContext(context.Background(), sourceKey, destinationKey)
+}
+
+func (b *BucketClient) CopyWithContext(ctx context.Context, sourceKey string, destinationKey string) (*FileUploadResponse, error) {
+	return b.client.CopyFileWithContext(ctx, b.id, sourceKey, destinationKey)
+}
+
+func (b *BucketClient) Info(path string) (*FileObject, error) {
+	return b.InfoWithContext(context.Background(), path)
+}
+
+func (b *BucketClient) InfoWithContext(ctx context.Context, path string) (*FileObject, error) {
+	return b.client.GetFileMetadataWithContext(ctx, b.id, path)
+}
+
+func (b *BucketClient) Exists(path string) (bool, error) {
+	return b.ExistsWithContext(context.Background(), path)
+}
+
+func (b *BucketClient) ExistsWithContext(ctx context.Context, path string) (bool, error) {
+	return b.client.FileExistsWithContext(ctx, b.id, path)
+}
+
+func (b *BucketClient) CreateSignedUrl(path string, expiresIn int, urlOptions ...UrlOptions) (*SignedUrlResponse, error) {
+	return b.CreateSignedUrlWithContext(context.Background(), path, expiresIn, urlOptions...)
+}
+
+func (b *BucketClient) CreateSignedUrlWithContext(ctx context.Context, path string, expiresIn int, urlOptions ...UrlOptions) (*SignedUrlResponse, error) {
+	return b.client.CreateSignedUrlWithContext(ctx, b.id, path, expiresIn, urlOptions...)
+}
+
+func (b *BucketClient) CreateSignedUrls(paths []string, expiresIn int) ([]SignedUrlResponse, error) {
+	return b.CreateSignedUrlsWithContext(context.Background(), paths, expiresIn)
+}
+
+func (b *BucketClient) CreateSignedUrlsWithContext(ctx context.Context, paths []string, expiresIn int) ([]SignedUrlResponse, error) {
+	return b.client.CreateSignedUrlsWithContext(ctx, b.id, paths, expiresIn)
+}
+
+func (b *BucketClient) GetPublicUrl(path string, urlOptions ...UrlOptions) SignedUrlResponse {
+	return b.client.GetPublicUrl(b.id, path, urlOptions...)
+}
@@ -1,8 +1,19 @@
 package storage_go
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -12,52 +23,415 @@ var (
 type Client struct {
 	clientError     error
 	session         http.Client
-	clientTransport transport
+	clientTransport *transport
+	retry           retryPolicy
+	rateLimit       rateLimitPolicy
+	timeout         time.Duration
+	tracer          trace.Tracer
+	// maxUploadSize is the largest upload in bytes sent to the server, zero for no limit
+	maxUploadSize int64
+	// decompress unwraps gzip encoded download bodies
+	decompress bool
+	// pool tunes the connection pool of a copy of the transport, nil uses the transport as it is
+	pool *poolConfig
+	// publicUrlBase replaces the base URL in the signed and public URLs handed out, when set
+	publicUrlBase string
 }
 
+// ClientOption configures optional client behaviour in NewClient
+type ClientOption func(c *Client)
+
 type transport struct {
-	header  http.Header
-	baseUrl url.URL
+	header http.Header
+	// authorization holds the Authorization header value of the token, it's swapped by SetAuthToken
+	authorization atomic.Value
+	baseUrl       url.URL
+	// base sends the requests once the client headers are applied, nil means http.DefaultTransport
+	base         http.RoundTripper
+	requestHook  func(*http.Request)
+	responseHook func(*http.Response, error)
+	logger       *log.Logger
 }
 
-func (t transport) RoundTrip(request *http.Request) (*http.Response, error) {
+func (t *transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the caller's request
+	request = request.Clone(request.Context())
+	// A request can drop the Authorization header by setting it to nil, see DownloadPublicFile
+	_, requestAuthorization := request.Header["Authorization"]
 	for headerName, values := range t.header {
+		// Headers set on the request itself win over the client-wide ones
+		if _, ok := request.Header[headerName]; ok {
+			continue
+		}
 		for _, val := range values {
 			request.Header.Add(headerName, val)
 		}
 	}
+	// The token wins over an Authorization given in the NewClient headers, which only applies without one
+	if !requestAuthorization {
+		if authorization, _ := t.authorization.Load().(string); authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+	}
 	request.URL = t.baseUrl.ResolveReference(request.URL)
-	return http.DefaultTransport.RoundTrip(request)
+
+	base := t.base
+	if base == nil {
+		base = defaultTransport()
+	}
+	if t.requestHook == nil && t.responseHook == nil && t.logger == nil {
+		return base.RoundTrip(request)
+	}
+
+	return t.roundTripWithHooks(base, request)
+}
+
+// NewClient creates a client for the storage API at rawUrl. An invalid rawUrl is reported by every
+// request of the client, use NewClientWithError to catch it up front instead. An Authorization in
+// headers is only sent while the client has no token.
+func NewClient(rawUrl string, token string, headers map[string]string, options ...ClientOption) *Client {
+	baseURL, err := parseBaseUrl(rawUrl)
+	if err != nil {
+		c := newClient(url.URL{}, token, headers, options...)
+		c.clientError = err
+		return c
+	}
+
+	return newClient(*baseURL, token, headers, options...)
 }
 
-func NewClient(rawUrl string, token string, headers map[string]string) *Client {
+// NewClientWithError is NewClient, but returns an error when rawUrl isn't an absolute http(s) URL
+// or one of the options is invalid
+func NewClientWithError(rawUrl string, token string, headers map[string]string, options ...ClientOption) (*Client, error) {
+	baseURL, err := parseBaseUrl(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newClient(*baseURL, token, headers, options...)
+	if c.clientError != nil {
+		return nil, c.clientError
+	}
+
+	return c, nil
+}
+
+// parseBaseUrl parses rawUrl, requiring the scheme and host every request is resolved against
+func parseBaseUrl(rawUrl string) (*url.URL, error) {
 	baseURL, err := url.Parse(rawUrl)
 	if err != nil {
-		return &Client{
-			clientError: err,
-		}
+		return nil, err
+	}
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return nil, fmt.Errorf("storage: base URL %q must use http or https", rawUrl)
+	}
+	if baseURL.Host == "" {
+		return nil, fmt.Errorf("storage: base URL %q has no host", rawUrl)
 	}
 
-	t := transport{
-		header:  http.Header{},
-		baseUrl: *baseURL,
+	return baseURL, nil
+}
+
+func newClient(baseURL url.URL, token string, headers map[string]string, options ...ClientOption) *Client {
+	t := &transport{
+		header: http.Header{},
 	}
+	t.setBaseUrl(baseURL)
 
 	c := Client{
 		session:         http.Client{Transport: t},
 		clientTransport: t,
+		rateLimit:       defaultRateLimitPolicy,
 	}
 
 	// Set required headers
 	c.clientTransport.header.Set("Accept", "application/json")
 	c.clientTransport.header.Set("Content-Type", "application/json")
 	c.clientTransport.header.Set("X-Client-Info", "storage-go/"+version)
-	c.clientTransport.header.Set("Authorization", "Bearer "+token)
+	c.clientTransport.header.Set("User-Agent", "storage-go/"+version)
+	c.SetAuthToken(token)
 
 	// Optional headers [if exists]
 	for key, value := range headers {
 		c.clientTransport.header.Set(key, value)
 	}
 
+	for _, option := range options {
+		option(&c)
+	}
+	if c.pool != nil {
+		c.clientTransport.base = c.pool.transport(c.clientTransport.base)
+	}
+
 	return &c
 }
+
+// setClientError records an invalid option, keeping the first one so later options can't hide it
+func (c *Client) setClientError(err error) {
+	if c.clientError == nil {
+		c.clientError = err
+	}
+}
+
+// setBaseUrl sets the URL requests are resolved against, without any trailing slash since the API
+// paths appended to it start with one
+func (t *transport) setBaseUrl(baseURL url.URL) {
+	baseURL.Path = strings.TrimRight(baseURL.Path, "/")
+	baseURL.RawPath = strings.TrimRight(baseURL.RawPath, "/")
+	t.baseUrl = baseURL
+}
+
+// WithBaseURL replaces the base URL given to NewClient, an invalid rawUrl is reported by every request
+func WithBaseURL(rawUrl string) ClientOption {
+	return func(c *Client) {
+		baseURL, err := parseBaseUrl(rawUrl)
+		if err != nil {
+			c.setClientError(err)
+			return
+		}
+		c.clientTransport.setBaseUrl(*baseURL)
+	}
+}
+
+// WithPathPrefix appends prefix, e.g. "/storage/v1", to the path of the base URL for deployments
+// that mount the storage API below a reverse proxy path
+func WithPathPrefix(prefix string) ClientOption {
+	return func(c *Client) {
+		prefix = strings.Trim(prefix, "/")
+		if prefix == "" {
+			return
+		}
+		baseURL := c.clientTransport.baseUrl
+		baseURL.Path += "/" + prefix
+		baseURL.RawPath = ""
+		c.clientTransport.setBaseUrl(baseURL)
+	}
+}
+
+// WithPublicURLBase builds the signed and public URLs against rawUrl, e.g. a CDN in front of the
+// storage API, instead of the base URL the client sends its requests to
+func WithPublicURLBase(rawUrl string) ClientOption {
+	return func(c *Client) {
+		publicURL, err := parseBaseUrl(rawUrl)
+		if err != nil {
+			c.setClientError(err)
+			return
+		}
+		c.publicUrlBase = strings.TrimRight(publicURL.String(), "/")
+	}
+}
+
+// urlBase is what the signed and public URLs handed out are relative to
+func (c *Client) urlBase() string {
+	if c.publicUrlBase != "" {
+		return c.publicUrlBase
+	}
+
+	return c.clientTransport.baseUrl.String()
+}
+
+// SetAuthToken replaces the bearer token sent by subsequent requests, including the ones of calls
+// already in flight. It's safe to call concurrently with requests. Without a token the client is
+// anonymous and sends no Authorization header, some gateways reject an empty bearer token.
+func (c *Client) SetAuthToken(token string) {
+	authorization := ""
+	if token != "" {
+		authorization = "Bearer " + token
+	}
+	c.clientTransport.authorization.Store(authorization)
+}
+
+// WithHeader adds a header to every request. Headers a request sets itself, like an upload content type,
+// take precedence, and the Authorization header is left to the token given to NewClient.
+func WithHeader(key string, value string) ClientOption {
+	return func(c *Client) {
+		if http.CanonicalHeaderKey(key) == "Authorization" {
+			return
+		}
+		c.clientTransport.header.Set(key, value)
+	}
+}
+
+// WithHeaders adds every header of headers to every request, see WithHeader
+func WithHeaders(headers map[string]string) ClientOption {
+	return func(c *Client) {
+		for key, value := range headers {
+			WithHeader(key, value)(c)
+		}
+	}
+}
+
+// WithUserAgent replaces the default "storage-go/<version>" User-Agent of every request
+func WithUserAgent(userAgent string) ClientOption {
+	return func(c *Client) {
+		c.clientTransport.header.Set("User-Agent", userAgent)
+	}
+}
+
+// WithHTTPClient sends requests through httpClient instead of a default one, keeping its timeout,
+// cookie jar, redirect policy and transport. The client headers are still added to every request.
+// A nil httpClient keeps the default one.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		if httpClient == nil {
+			return
+		}
+		c.clientTransport.base = httpClient.Transport
+		c.session = *httpClient
+		c.session.Transport = c.clientTransport
+	}
+}
+
+// WithTransportConfig tunes the connection pool: the idle connections kept overall and per host, and
+// how long they are kept. A zero value picks 100, 100 and 90s, which unlike Go's default of 2 idle
+// connections per host suits many concurrent uploads to the one storage host.
+//
+// Without this option clients share a copy of the stock http.DefaultTransport pooled with these
+// defaults; an http.DefaultTransport replaced by the program, e.g. by a mocking library, is used as
+// it is. With it the client gets its own copy of http.DefaultTransport, taken when it's created. It
+// applies to the transport of WithHTTPClient too when that's an *http.Transport, on a copy.
+func WithTransportConfig(maxIdleConns int, maxIdleConnsPerHost int, idleTimeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.pool = &poolConfig{
+			maxIdleConns:        maxIdleConns,
+			maxIdleConnsPerHost: maxIdleConnsPerHost,
+			idleTimeout:         idleTimeout,
+		}
+	}
+}
+
+// poolConfig is the connection pool configuration of WithTransportConfig
+type poolConfig struct {
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleTimeout         time.Duration
+}
+
+// defaultPool is the pool of the clients without WithTransportConfig, it also fills the values left
+// zero in WithTransportConfig
+var defaultPool = poolConfig{maxIdleConns: 100, maxIdleConnsPerHost: 100, idleTimeout: 90 * time.Second}
+
+var (
+	// stockTransport is http.DefaultTransport as the standard library sets it up
+	stockTransport = http.DefaultTransport
+
+	pooledDefaultOnce sync.Once
+	pooledDefault     http.RoundTripper
+)
+
+// defaultTransport returns the transport of the clients without one of their own: the stock
+// http.DefaultTransport with defaultPool applied, shared so clients created per request reuse the
+// same connections, or the program's replacement of http.DefaultTransport.
+func defaultTransport() http.RoundTripper {
+	if http.DefaultTransport != stockTransport {
+		return http.DefaultTransport
+	}
+	pooledDefaultOnce.Do(func() {
+		pooledDefault = defaultPool.transport(stockTransport)
+	})
+
+	return pooledDefault
+}
+
+// transport returns a copy of base, or of http.DefaultTransport when base is nil, with the pool
+// configuration applied. Transports other than *http.Transport are returned as they are.
+func (p poolConfig) transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	httpTransport, ok := base.(*http.Transport)
+	if !ok {
+		return base
+	}
+
+	pooled := httpTransport.Clone()
+	pooled.MaxIdleConns = defaultPool.maxIdleConns
+	if p.maxIdleConns > 0 {
+		pooled.MaxIdleConns = p.maxIdleConns
+	}
+	pooled.MaxIdleConnsPerHost = defaultPool.maxIdleConnsPerHost
+	if p.maxIdleConnsPerHost > 0 {
+		pooled.MaxIdleConnsPerHost = p.maxIdleConnsPerHost
+	}
+	pooled.IdleConnTimeout = defaultPool.idleTimeout
+	if p.idleTimeout > 0 {
+		pooled.IdleConnTimeout = p.idleTimeout
+	}
+
+	return pooled
+}
+
+// WithTimeout bounds every storage operation, including retries and reading the response body, to d.
+// It composes with any deadline already set on the caller's context, the earliest one wins.
+func WithTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithMaxUploadSize rejects uploads larger than maxBytes with ErrUploadTooLarge. Uploads of known size
+// fail before any request is sent, others are aborted once they stream past the limit.
+func WithMaxUploadSize(maxBytes int64) ClientOption {
+	return func(c *Client) {
+		c.maxUploadSize = maxBytes
+	}
+}
+
+// newRequest builds a request bound to ctx for an API path relative to the base URL, e.g. "/object/move"
+func (c *Client) newRequest(ctx context.Context, method string, path string, body io.Reader) (*http.Request, error) {
+	if c.clientError != nil {
+		return nil, c.clientError
+	}
+
+	return http.NewRequestWithContext(ctx, method, c.clientTransport.baseUrl.String()+path, body)
+}
+
+// do sends the request, applying the client timeout and retry policy
+func (c *Client) do(request *http.Request) (*http.Response, error) {
+	if c.tracer != nil {
+		return c.doWithSpan(request)
+	}
+
+	return c.doWithTimeout(request)
+}
+
+// doWithTimeout sends the request with the retry policy, bounded by the client timeout
+func (c *Client) doWithTimeout(request *http.Request) (*http.Response, error) {
+	if c.timeout <= 0 {
+		return c.doWithRetry(request)
+	}
+
+	ctx, cancel := context.WithTimeout(request.Context(), c.timeout)
+	res, err := c.doWithRetry(request.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The deadline has to outlive this call since the caller still reads the body
+	res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+
+	return res, nil
+}
+
+// maxDrain bounds how much of an unread response body is read to keep the connection reusable,
+// larger leftovers are cheaper to drop with the connection
+const maxDrain = 64 << 10
+
+// drainAndClose discards the unread rest of body, up to maxDrain bytes, and closes it so the
+// connection can be reused for the next request
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, io.LimitReader(body, maxDrain))
+	body.Close()
+}
+
+// cancelOnClose releases the request context once the response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
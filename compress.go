@@ -0,0 +1,65 @@
+package storage_go
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// WithDecompression makes downloads of objects served with Content-Encoding: gzip, such as the ones
+// uploaded with FileOptions.Compress, return the decompressed bytes. The default transport already
+// does this unless the request sets its own Accept-Encoding, in which case the bytes are left as stored.
+func WithDecompression() ClientOption {
+	return func(c *Client) {
+		c.decompress = true
+	}
+}
+
+// gzipBody streams body gzip compressed. The compression runs in a goroutine, closing the returned
+// reader stops it.
+func gzipBody(body io.Reader) io.ReadCloser {
+	reader, writer := io.Pipe()
+
+	go func() {
+		compressed := gzip.NewWriter(writer)
+		_, err := io.Copy(compressed, body)
+		if err == nil {
+			err = compressed.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	return reader
+}
+
+// decompressResponse swaps the body of a gzip encoded response for its decompressed stream. Partial
+// responses are left alone since a byte range of a gzip stream can't be decompressed on its own.
+func decompressResponse(res *http.Response) error {
+	if res.Uncompressed || res.StatusCode == http.StatusPartialContent ||
+		!strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		return nil
+	}
+
+	decompressed, err := gzip.NewReader(res.Body)
+	if err != nil {
+		return err
+	}
+	res.Body = &gzipReadCloser{Reader: decompressed, body: res.Body}
+	res.ContentLength = -1
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+
+	return nil
+}
+
+// gzipReadCloser reads the decompressed stream and closes the response body under it
+type gzipReadCloser struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (r *gzipReadCloser) Close() error {
+	r.Reader.Close()
+	return r.body.Close()
+}
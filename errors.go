@@ -0,0 +1,118 @@
+package storage_go
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrUploadTooLarge is returned, possibly wrapped, for uploads over the WithMaxUploadSize limit
+var ErrUploadTooLarge = errors.New("storage: upload exceeds the maximum upload size")
+
+// ErrObjectExists is matched by errors.Is when an upload, copy or move without upsert targets an
+// existing object (409 Conflict), e.g. to fall back to UpdateFile:
+//
+//	_, err := client.UploadFile(bucketId, path, data)
+//	if errors.Is(err, storage_go.ErrObjectExists) { ... }
+var ErrObjectExists = errors.New("storage: object already exists")
+
+// ErrObjectNotFound is matched by errors.Is when a download, metadata, signing, copy or move call
+// targets an object that doesn't exist (404 Not Found)
+var ErrObjectNotFound = errors.New("storage: object not found")
+
+// StorageError is returned when the storage API responds with a non-2xx status code.
+// Use errors.As to inspect it:
+//
+//	var storageErr *storage_go.StorageError
+//	if errors.As(err, &storageErr) && storageErr.StatusCode == 404 { ... }
+type StorageError struct {
+	// StatusCode is the status reported in the error body, or the HTTP status when the body has none
+	StatusCode int
+	Message    string
+	// Err is the short error name from the body (e.g. "Not found"); it can't be called Error
+	// since that would clash with the error interface
+	Err     string
+	RawBody []byte
+	// sentinel is the package error the status stands for in the failed operation, if any
+	sentinel error
+}
+
+func (e *StorageError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = e.Err
+	}
+	if message == "" {
+		message = http.StatusText(e.StatusCode)
+	}
+	return "storage: " + strconv.Itoa(e.StatusCode) + " " + message
+}
+
+// Unwrap returns the sentinel error, such as ErrObjectNotFound, the failure stands for
+func (e *StorageError) Unwrap() error {
+	return e.sentinel
+}
+
+// parseErrorResponse reads the body of a failed response and turns it into a *StorageError
+func parseErrorResponse(res *http.Response) error {
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	return newStorageError(res.StatusCode, body)
+}
+
+// newStorageError builds a StorageError from the status code and JSON error body of a response.
+// Supabase Storage error bodies look like {"statusCode":"404","error":"Not found","message":"Object not found"}
+func newStorageError(statusCode int, body []byte) *StorageError {
+	var errorBody struct {
+		StatusCode json.RawMessage `json:"statusCode"`
+		Error      string          `json:"error"`
+		Message    string          `json:"message"`
+	}
+	_ = json.Unmarshal(body, &errorBody)
+
+	// The body status code may be sent as a string or a number, and is more precise than the
+	// HTTP status (older servers answer 400 for a missing object but report 404 in the body)
+	if code, err := strconv.Atoi(strings.Trim(string(errorBody.StatusCode), `"`)); err == nil && code > 0 {
+		statusCode = code
+	}
+
+	return &StorageError{
+		StatusCode: statusCode,
+		Message:    errorBody.Message,
+		Err:        errorBody.Error,
+		RawBody:    body,
+	}
+}
+
+// objectError tags the StorageError of a failed operation on existing objects with the sentinel of
+// its status
+func objectError(err error) error {
+	err = tagError(err, http.StatusNotFound, ErrObjectNotFound)
+	return tagError(err, http.StatusConflict, ErrObjectExists)
+}
+
+// uploadError is objectError for uploads, which answer 404 for a missing bucket rather than object
+func uploadError(err error) error {
+	return tagError(err, http.StatusConflict, ErrObjectExists)
+}
+
+// tagError sets sentinel on err when it's a StorageError with the given status
+func tagError(err error, statusCode int, sentinel error) error {
+	var storageErr *StorageError
+	if errors.As(err, &storageErr) && storageErr.StatusCode == statusCode {
+		storageErr.sentinel = sentinel
+	}
+
+	return err
+}
+
+// isSuccess reports whether the response carries a 2xx status code
+func isSuccess(res *http.Response) bool {
+	return res.StatusCode >= 200 && res.StatusCode < 300
+}
@@ -0,0 +1,62 @@
+package storage_go
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// GetVersion returns the version the storage server reports, e.g. for a readiness probe
+func (c *Client) GetVersion() (string, error) {
+	return c.GetVersionWithContext(context.Background())
+}
+
+func (c *Client) GetVersionWithContext(ctx context.Context) (string, error) {
+	request, err := c.newRequest(ctx, http.MethodGet, "/version", nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := c.do(request)
+	if err != nil {
+		return "", err
+	}
+	defer drainAndClose(res.Body)
+
+	if !isSuccess(res) {
+		return "", parseErrorResponse(res)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	// The version is sent as plain text
+	return strings.TrimSpace(string(body)), nil
+}
+
+// Health checks the storage server is reachable and ready to serve requests
+func (c *Client) Health() error {
+	return c.HealthWithContext(context.Background())
+}
+
+func (c *Client) HealthWithContext(ctx context.Context) error {
+	request, err := c.newRequest(ctx, http.MethodGet, "/status", nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.do(request)
+	if err != nil {
+		return err
+	}
+	defer drainAndClose(res.Body)
+
+	if !isSuccess(res) {
+		return parseErrorResponse(res)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package storage_go
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// redactedHeaders are replaced before a request is handed to a hook or logged
+var redactedHeaders = []string{"Authorization", "Apikey"}
+
+// WithRequestHook calls hook with every request right before it's sent, including retries.
+// The request is a copy with its credentials redacted, the hook must not read its body.
+func WithRequestHook(hook func(*http.Request)) ClientOption {
+	return func(c *Client) {
+		c.clientTransport.requestHook = hook
+	}
+}
+
+// WithResponseHook calls hook with the outcome of every request, including retries. The response
+// Request is the redacted copy given to the request hook, the hook must not read the response body.
+func WithResponseHook(hook func(*http.Response, error)) ClientOption {
+	return func(c *Client) {
+		c.clientTransport.responseHook = hook
+	}
+}
+
+// WithLogger logs the method, URL, status and duration of every request to logger
+func WithLogger(logger *log.Logger) ClientOption {
+	return func(c *Client) {
+		c.clientTransport.logger = logger
+	}
+}
+
+func (t *transport) roundTripWithHooks(base http.RoundTripper, request *http.Request) (*http.Response, error) {
+	redacted := redactRequest(request)
+	if t.requestHook != nil {
+		t.requestHook(redacted)
+	}
+
+	start := time.Now()
+	res, err := base.RoundTrip(request)
+	elapsed := time.Since(start)
+
+	if t.responseHook != nil {
+		if res != nil {
+			hookResponse := *res
+			hookResponse.Request = redacted
+			t.responseHook(&hookResponse, err)
+		} else {
+			t.responseHook(nil, err)
+		}
+	}
+
+	if t.logger != nil {
+		if err != nil {
+			t.logger.Printf("storage: %s %s failed after %s: %v", redacted.Method, redacted.URL, elapsed, err)
+		} else {
+			t.logger.Printf("storage: %s %s %d (%s)", redacted.Method, redacted.URL, res.StatusCode, elapsed)
+		}
+	}
+
+	return res, err
+}
+
+// redactRequest returns a copy of request without its credentials
+func redactRequest(request *http.Request) *http.Request {
+	redacted := request.Clone(request.Context())
+	for _, header := range redactedHeaders {
+		if redacted.Header.Get(header) != "" {
+			redacted.Header.Set(header, "REDACTED")
+		}
+	}
+
+	return redacted
+}
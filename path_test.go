@@ -0,0 +1,28 @@
+package storage_go
+
+import (
+	"regexp"
+	"testing"
+)
+
+// BenchmarkRemoveEmptyFolderName compares removeEmptyFolderName to the regexp it replaced
+func BenchmarkRemoveEmptyFolderName(b *testing.B) {
+	const path = "users//42///avatars//avatar.png"
+	slashes := regexp.MustCompile("/+")
+	if got, want := removeEmptyFolderName(path), slashes.ReplaceAllString(path, "/"); got != want {
+		b.Fatalf("expected %q, got %q", want, got)
+	}
+
+	b.Run("Loop", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			removeEmptyFolderName(path)
+		}
+	})
+	b.Run("Regexp", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			slashes.ReplaceAllString(path, "/")
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package storage_go
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	tusVersion = "1.0.0"
+	// defaultResumableChunkSize is the chunk size Supabase Storage requires for every chunk but the last
+	defaultResumableChunkSize = 6 * 1024 * 1024
+)
+
+// ResumableOptions configures UploadFileResumable
+type ResumableOptions struct {
+	// ContentType is inferred from the file extension when empty
+	ContentType string
+	Upsert      bool
+	// ChunkSize is the number of bytes sent per PATCH request, 6MB when zero
+	ChunkSize int64
+	// UploadUrl resumes an upload previously created for the same object instead of starting a new one
+	UploadUrl string
+	// OnProgress is called after every chunk with the confirmed offset, persist it together with the
+	// upload URL to resume after a crash
+	OnProgress func(offset int64, size int64)
+}
+
+// ResumableUpload is an upload using the TUS resumable protocol. Keep Url to resume it later,
+// either with Resume or by passing it as ResumableOptions.UploadUrl.
+type ResumableUpload struct {
+	// Url identifies the upload on the server
+	Url string
+	// Offset is the number of bytes the server confirmed it received
+	Offset int64
+	Size   int64
+
+	client  *Client
+	data    io.ReaderAt
+	options ResumableOptions
+}
+
+// UploadFileResumable uploads size bytes of data in chunks with the TUS protocol. When the upload fails
+// midway the returned ResumableUpload is not nil and can be continued with Resume.
+func (c *Client) UploadFileResumable(bucketId string, relativePath string, data io.ReaderAt, size int64, opts ResumableOptions) (*ResumableUpload, error) {
+	return c.UploadFileResumableWithContext(context.Background(), bucketId, relativePath, data, size, opts)
+}
+
+func (c *Client) UploadFileResumableWithContext(ctx context.Context, bucketId string, relativePath string, data io.ReaderAt, size int64, opts ResumableOptions) (*ResumableUpload, error) {
+	if err := c.checkUploadSize(size); err != nil {
+		return nil, err
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultResumableChunkSize
+	}
+
+	upload := &ResumableUpload{
+		Url:     opts.UploadUrl,
+		Size:    size,
+		client:  c,
+		data:    data,
+		options: opts,
+	}
+	if upload.Url != "" {
+		return upload, upload.ResumeWithContext(ctx)
+	}
+
+	if err := upload.create(ctx, bucketId, relativePath); err != nil {
+		return nil, err
+	}
+
+	return upload, upload.sendChunks(ctx)
+}
+
+// Resume asks the server how much of the upload it received and sends the rest
+func (u *ResumableUpload) Resume() error {
+	return u.ResumeWithContext(context.Background())
+}
+
+func (u *ResumableUpload) ResumeWithContext(ctx context.Context) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, u.Url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Tus-Resumable", tusVersion)
+
+	res, err := u.client.do(request)
+	if err != nil {
+		return err
+	}
+	defer drainAndClose(res.Body)
+
+	if !isSuccess(res) {
+		return &StorageError{StatusCode: res.StatusCode}
+	}
+
+	offset, err := strconv.ParseInt(res.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return errors.New("storage: resumable upload is missing its Upload-Offset")
+	}
+	u.Offset = offset
+
+	return u.sendChunks(ctx)
+}
+
+// create registers the upload with the server and stores its URL
+func (u *ResumableUpload) create(ctx context.Context, bucketId string, relativePath string) error {
+	objectName, err := normalizeObjectKey(relativePath)
+	if err != nil {
+		return err
+	}
+
+	contentType := u.options.ContentType
+	if contentType == "" {
+		contentType = detectContentType(relativePath)
+	}
+
+	request, err := u.client.newRequest(ctx, http.MethodPost, "/upload/resumable", nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Tus-Resumable", tusVersion)
+	request.Header.Set("Upload-Length", strconv.FormatInt(u.Size, 10))
+	request.Header.Set("Upload-Metadata", tusMetadata(map[string]string{
+		"bucketName":   bucketId,
+		"objectName":   objectName,
+		"contentType":  contentType,
+		"cacheControl": defaultFileCacheControl,
+	}))
+	request.Header.Set("x-upsert", strconv.FormatBool(u.options.Upsert))
+
+	res, err := u.client.do(request)
+	if err != nil {
+		return err
+	}
+	defer drainAndClose(res.Body)
+
+	if !isSuccess(res) {
+		return uploadError(parseErrorResponse(res))
+	}
+
+	location, err := res.Location()
+	if err != nil {
+		return errors.New("storage: resumable upload was created without a Location")
+	}
+	u.Url = location.String()
+
+	return nil
+}
+
+// sendChunks PATCHes the data from the current offset until the upload is complete
+func (u *ResumableUpload) sendChunks(ctx context.Context) error {
+	for u.Offset < u.Size {
+		length := u.options.ChunkSize
+		if remaining := u.Size - u.Offset; remaining < length {
+			length = remaining
+		}
+
+		request, err := http.NewRequestWithContext(ctx, http.MethodPatch, u.Url, io.NewSectionReader(u.data, u.Offset, length))
+		if err != nil {
+			return err
+		}
+		request.ContentLength = length
+		request.Header.Set("Tus-Resumable", tusVersion)
+		request.Header.Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+		request.Header.Set("Content-Type", "application/offset+octet-stream")
+
+		res, err := u.client.do(request)
+		if err != nil {
+			return err
+		}
+		if !isSuccess(res) {
+			err := parseErrorResponse(res)
+			drainAndClose(res.Body)
+			return err
+		}
+		drainAndClose(res.Body)
+
+		offset, err := strconv.ParseInt(res.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			return errors.New("storage: resumable chunk response is missing its Upload-Offset")
+		}
+		// An offset that doesn't move forward would resend the same chunk forever
+		if offset <= u.Offset || offset > u.Size {
+			return fmt.Errorf("storage: resumable chunk response has Upload-Offset %d after offset %d of %d bytes", offset, u.Offset, u.Size)
+		}
+		u.Offset = offset
+
+		if u.options.OnProgress != nil {
+			u.options.OnProgress(u.Offset, u.Size)
+		}
+	}
+
+	return nil
+}
+
+// tusMetadata encodes the Upload-Metadata header, comma separated keys with base64 values
+func tusMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,213 @@
+package storage_go
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// idempotencyKeyHeader lets the server dedupe a request sent several times
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// retryPolicy controls how failed requests are retried, the zero value disables retries
+type retryPolicy struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxElapsed time.Duration
+}
+
+// WithRetry retries idempotent requests (GET, HEAD, PUT, DELETE, and any request carrying an
+// Idempotency-Key header) up to maxRetries times on transport errors and 429/502/503/504 responses,
+// waiting baseDelay*2^n between attempts or the Retry-After the server asked for. A Retry-After is
+// never waited for longer than the WithRateLimitRetry max delay, 10 seconds by default.
+//
+// A request is only retried if its body can be replayed. Uploads from readers that don't implement
+// io.Seeker are sent once, since the bytes already consumed can't be read again.
+func WithRetry(maxRetries int, baseDelay time.Duration) ClientOption {
+	return func(c *Client) {
+		c.retry.maxRetries = maxRetries
+		c.retry.baseDelay = baseDelay
+	}
+}
+
+// WithRetryMaxElapsed stops retrying a call once the next attempt would start after d has elapsed.
+// Zero, the default, sets no limit beyond maxRetries and the capped delays.
+func WithRetryMaxElapsed(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.retry.maxElapsed = d
+	}
+}
+
+// rateLimitPolicy controls how requests answered with 429 Too Many Requests and a Retry-After are
+// retried when the general retry policy doesn't apply to them
+type rateLimitPolicy struct {
+	maxRetries int
+	maxDelay   time.Duration
+}
+
+var defaultRateLimitPolicy = rateLimitPolicy{maxRetries: 1, maxDelay: 10 * time.Second}
+
+// WithRateLimitRetry retries any request answered with 429 Too Many Requests and a Retry-After header
+// up to maxRetries times, waiting for the Retry-After but never longer than maxDelay. Since the server
+// didn't process the request, uploads are retried too, as long as their body can be replayed.
+// By default such requests are retried once, waiting up to 10 seconds, maxRetries 0 disables it.
+func WithRateLimitRetry(maxRetries int, maxDelay time.Duration) ClientOption {
+	return func(c *Client) {
+		c.rateLimit.maxRetries = maxRetries
+		c.rateLimit.maxDelay = maxDelay
+	}
+}
+
+// doWithRetry sends the request, retrying it according to the client's retry policy
+func (c *Client) doWithRetry(request *http.Request) (*http.Response, error) {
+	res, err := c.session.Do(request)
+	if c.retry.maxRetries <= 0 || !isIdempotent(request) {
+		return c.retryRateLimited(request, res, err)
+	}
+
+	start := time.Now()
+	for attempt := 1; attempt <= c.retry.maxRetries && shouldRetry(request, res, err); attempt++ {
+		delay := c.retry.delay(attempt, res, c.maxRetryAfter())
+		if c.retry.maxElapsed > 0 && time.Since(start)+delay > c.retry.maxElapsed {
+			break
+		}
+
+		retryRes, ok, retryErr := c.resend(request, res, delay)
+		if !ok {
+			break
+		}
+		res, err = retryRes, retryErr
+	}
+
+	return res, err
+}
+
+// retryRateLimited retries the request while it's answered with 429 and a Retry-After
+func (c *Client) retryRateLimited(request *http.Request, res *http.Response, err error) (*http.Response, error) {
+	for attempt := 1; attempt <= c.rateLimit.maxRetries && err == nil && res.StatusCode == http.StatusTooManyRequests; attempt++ {
+		delay, ok := parseRetryAfter(res.Header.Get("Retry-After"))
+		if !ok {
+			break
+		}
+		if delay > c.rateLimit.maxDelay {
+			delay = c.rateLimit.maxDelay
+		}
+
+		retryRes, ok, retryErr := c.resend(request, res, delay)
+		if !ok {
+			break
+		}
+		res, err = retryRes, retryErr
+	}
+
+	return res, err
+}
+
+// resend waits for delay and sends request again, discarding the previous response res. It returns
+// false, leaving res untouched, when the request body can't be replayed.
+func (c *Client) resend(request *http.Request, res *http.Response, delay time.Duration) (*http.Response, bool, error) {
+	retryRequest, ok := rewindRequest(request)
+	if !ok {
+		return nil, false, nil
+	}
+
+	if res != nil {
+		drainAndClose(res.Body)
+	}
+
+	timer := time.NewTimer(delay)
+	select {
+	case <-request.Context().Done():
+		timer.Stop()
+		return nil, true, request.Context().Err()
+	case <-timer.C:
+	}
+
+	res, err := c.session.Do(retryRequest)
+	return res, true, err
+}
+
+// maxRetryAfter returns the longest Retry-After the client waits for before retrying
+func (c *Client) maxRetryAfter() time.Duration {
+	if c.rateLimit.maxDelay > 0 {
+		return c.rateLimit.maxDelay
+	}
+	return defaultRateLimitPolicy.maxDelay
+}
+
+// delay returns how long to wait before the given attempt, honoring a Retry-After header up to
+// maxRetryAfter
+func (p retryPolicy) delay(attempt int, res *http.Response, maxRetryAfter time.Duration) time.Duration {
+	if res != nil {
+		if retryAfter, ok := parseRetryAfter(res.Header.Get("Retry-After")); ok {
+			if retryAfter > maxRetryAfter {
+				return maxRetryAfter
+			}
+			return retryAfter
+		}
+	}
+
+	return p.baseDelay << uint(attempt-1)
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// rewindRequest returns a copy of request with a fresh body, or false if the body can't be replayed
+func rewindRequest(request *http.Request) (*http.Request, bool) {
+	retryRequest := request.Clone(request.Context())
+	if request.Body == nil || request.Body == http.NoBody {
+		return retryRequest, true
+	}
+	if request.GetBody == nil {
+		return nil, false
+	}
+
+	body, err := request.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	retryRequest.Body = body
+
+	return retryRequest, true
+}
+
+func shouldRetry(request *http.Request, res *http.Response, err error) bool {
+	if err != nil {
+		// The caller gave up, retrying would only delay reporting it
+		return request.Context().Err() == nil
+	}
+
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// isIdempotent reports whether the request can be sent again, either by its method or because the
+// server dedupes it by its idempotency key
+func isIdempotent(request *http.Request) bool {
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return request.Header.Get(idempotencyKeyHeader) != ""
+}
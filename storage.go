@@ -3,12 +3,25 @@ package storage_go
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
-	"regexp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -17,131 +30,1108 @@ const (
 	defaultFileCacheControl = "3600"
 	defaultFileContentType  = "text/plain;charset=UTF-8"
 	defaultFileUpsert       = false
-	defaultSortColumn       = "name"
-	defaultSortOrder        = "asc"
+	defaultSortColumn       = SortByName
+	defaultSortOrder        = SortAsc
+	// sniffLen is the number of bytes http.DetectContentType considers
+	sniffLen = 512
 )
 
-func (c *Client) UploadOrUpdateFile(bucketId string, relativePath string, data io.Reader, update bool) FileUploadResponse {
-	c.clientTransport.header.Set("cache-control", defaultFileCacheControl)
-	c.clientTransport.header.Set("content-type", defaultFileContentType)
-	c.clientTransport.header.Set("x-upsert", strconv.FormatBool(defaultFileUpsert))
+// UploadOrUpdateFile creates (POST) or replaces (update, PUT) the object, upsert is sent on both methods
+func (c *Client) UploadOrUpdateFile(bucketId string, relativePath string, data io.Reader, update bool, upsert bool) (*FileUploadResponse, error) {
+	return c.UploadOrUpdateFileWithContext(context.Background(), bucketId, relativePath, data, update, upsert)
+}
+
+func (c *Client) UploadOrUpdateFileWithContext(ctx context.Context, bucketId string, relativePath string, data io.Reader, update bool, upsert bool) (*FileUploadResponse, error) {
+	if update {
+		return c.UpdateFileWithOptionsWithContext(ctx, bucketId, relativePath, data, FileOptions{Upsert: upsert})
+	}
+	return c.UploadFileWithOptionsWithContext(ctx, bucketId, relativePath, data, FileOptions{Upsert: upsert})
+}
+
+// UploadFileWithOptions uploads data the way UploadFile does, configured by options
+func (c *Client) UploadFileWithOptions(bucketId string, relativePath string, data io.Reader, options FileOptions) (*FileUploadResponse, error) {
+	return c.UploadFileWithOptionsWithContext(context.Background(), bucketId, relativePath, data, options)
+}
+
+func (c *Client) UploadFileWithOptionsWithContext(ctx context.Context, bucketId string, relativePath string, data io.Reader, options FileOptions) (*FileUploadResponse, error) {
+	return c.uploadOrUpdateFile(ctx, bucketId, relativePath, data, false, options)
+}
 
+// UpdateFileWithOptions replaces the object the way UpdateFile does, configured by options
+func (c *Client) UpdateFileWithOptions(bucketId string, relativePath string, data io.Reader, options FileOptions) (*FileUploadResponse, error) {
+	return c.UpdateFileWithOptionsWithContext(context.Background(), bucketId, relativePath, data, options)
+}
+
+func (c *Client) UpdateFileWithOptionsWithContext(ctx context.Context, bucketId string, relativePath string, data io.Reader, options FileOptions) (*FileUploadResponse, error) {
+	return c.uploadOrUpdateFile(ctx, bucketId, relativePath, data, true, options)
+}
+
+// UploadFileFromPath uploads the local file at localPath, its content type is inferred from its extension
+func (c *Client) UploadFileFromPath(bucketId string, relativePath string, localPath string) (*FileUploadResponse, error) {
+	return c.UploadFileFromPathWithContext(context.Background(), bucketId, relativePath, localPath)
+}
+
+func (c *Client) UploadFileFromPathWithContext(ctx context.Context, bucketId string, relativePath string, localPath string) (*FileUploadResponse, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return c.uploadOrUpdateFile(ctx, bucketId, relativePath, file, false, FileOptions{
+		ContentType: mime.TypeByExtension(filepath.Ext(localPath)),
+	})
+}
+
+func (c *Client) uploadOrUpdateFile(ctx context.Context, bucketId string, relativePath string, data io.Reader, update bool, options FileOptions) (*FileUploadResponse, error) {
 	body := bufio.NewReader(data)
-	_path := removeEmptyFolderName(bucketId + "/" + relativePath)
+	_path, err := objectPath(bucketId, relativePath)
+	if err != nil {
+		return nil, err
+	}
+
+	method := http.MethodPost
+	if update {
+		method = http.MethodPut
+	}
+
+	// Measured before the body is read, sniffing the content type or streaming it moves the reader
+	size := dataSize(data)
+	replayOffset := int64(-1)
+	if seeker, ok := data.(io.Seeker); ok {
+		if offset, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			replayOffset = offset
+		}
+	}
+
+	contentType := options.ContentType
+	if contentType == "" && options.DetectContentType && mime.TypeByExtension(path.Ext(relativePath)) == "" {
+		// Peek keeps the sniffed bytes buffered, so they are still part of the uploaded body
+		sniffed, _ := body.Peek(sniffLen)
+		contentType = http.DetectContentType(sniffed)
+	}
+	if contentType == "" {
+		contentType = detectContentType(relativePath)
+	}
+
+	if err := c.checkUploadSize(size); err != nil {
+		return nil, err
+	}
+	if options.PreserveMetadata && (update || options.Upsert) {
+		if options.Metadata, err = c.preservedMetadata(ctx, bucketId, relativePath, options.Metadata); err != nil {
+			return nil, err
+		}
+	}
+	// wrap applies the per upload reader options to the body and any replay of it
+	wrap := c.limitUpload(size)
+	if options.OnProgress != nil {
+		wrapLimited := wrap
+		wrap = func(body io.Reader) io.Reader {
+			return &progressReader{reader: wrapLimited(body), total: size, onProgress: options.OnProgress}
+		}
+	}
+	cacheControl := options.CacheControl
+	if cacheControl == "" {
+		cacheControl = defaultFileCacheControl
+	}
+
+	requestContentType := contentType
+	if options.UseMultipart {
+		// The boundary is fixed up front so replayed bodies match the content type header
+		boundary := multipart.NewWriter(nil).Boundary()
+		fileName := path.Base(relativePath)
+		wrapFile := wrap
+		wrap = func(body io.Reader) io.Reader {
+			return multipartBody(wrapFile(body), boundary, fileName, contentType, cacheControl, options.Metadata, options.ContentDisposition)
+		}
+		requestContentType = "multipart/form-data; boundary=" + boundary
+	}
+	if options.Compress {
+		// Compressed last so the whole request body matches the Content-Encoding
+		wrapPlain := wrap
+		wrap = func(body io.Reader) io.Reader {
+			return gzipBody(wrapPlain(body))
+		}
+	}
+
+	requestBody := wrap(body)
+	request, err := c.newRequest(ctx, method, "/object/"+_path, requestBody)
+	if err != nil {
+		// Stops the multipart writer, if any
+		if closer, ok := requestBody.(io.Closer); ok {
+			closer.Close()
+		}
+		return nil, err
+	}
+	// A known length lets the server reject an oversized upload before it's streamed,
+	// multipart forms and compressed bodies are still sent chunked
+	if size > 0 && !options.UseMultipart && !options.Compress {
+		request.ContentLength = size
+	}
+	// Seekable data can be replayed when the request is retried
+	if replayOffset >= 0 {
+		request.GetBody = func() (io.ReadCloser, error) {
+			if _, err := data.(io.Seeker).Seek(replayOffset, io.SeekStart); err != nil {
+				return nil, err
+			}
+			replay := wrap(bufio.NewReader(data))
+			if closer, ok := replay.(io.ReadCloser); ok {
+				return closer, nil
+			}
+			return ioutil.NopCloser(replay), nil
+		}
+	}
+
+	// Upload headers are set per request, the client header is shared by concurrent calls
+	request.Header.Set("cache-control", cacheControl)
+	request.Header.Set("content-type", requestContentType)
+	request.Header.Set("x-upsert", strconv.FormatBool(options.Upsert))
+	if options.Metadata != nil {
+		request.Header.Set("x-metadata", metadataHeader(options.Metadata))
+	}
+	if options.Compress {
+		request.Header.Set("content-encoding", "gzip")
+	}
+	if options.ContentDisposition != "" {
+		request.Header.Set("content-disposition", options.ContentDisposition)
+	}
+	if options.IdempotencyKey != "" {
+		request.Header.Set(idempotencyKeyHeader, options.IdempotencyKey)
+	}
+	for key, value := range options.Headers {
+		request.Header.Set(key, value)
+	}
+
+	res, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+
+	if !isSuccess(res) {
+		return nil, uploadError(parseErrorResponse(res))
+	}
+
+	var response FileUploadResponse
+	err = decodeResponse(res.Body, &response)
+	if err != nil {
+		return nil, err
+	}
+	response.setResponse(res)
+
+	return &response, nil
+}
+
+func (c *Client) UpdateFile(bucketId string, relativePath string, data io.Reader) (*FileUploadResponse, error) {
+	return c.UpdateFileWithContext(context.Background(), bucketId, relativePath, data)
+}
+
+func (c *Client) UpdateFileWithContext(ctx context.Context, bucketId string, relativePath string, data io.Reader) (*FileUploadResponse, error) {
+	return c.UpdateFileWithOptionsWithContext(ctx, bucketId, relativePath, data, FileOptions{Upsert: defaultFileUpsert})
+}
+
+// UpdateFileUpsert replaces the object, creating it when it doesn't exist yet, in a single request
+func (c *Client) UpdateFileUpsert(bucketId string, relativePath string, data io.Reader) (*FileUploadResponse, error) {
+	return c.UpdateFileUpsertWithContext(context.Background(), bucketId, relativePath, data)
+}
+
+func (c *Client) UpdateFileUpsertWithContext(ctx context.Context, bucketId string, relativePath string, data io.Reader) (*FileUploadResponse, error) {
+	// Updates (PUT) fail for missing objects, an upserting upload covers both cases
+	return c.UploadFileWithOptionsWithContext(ctx, bucketId, relativePath, data, FileOptions{Upsert: true})
+}
+
+// UploadFile creates the object from data. Data of unknown size, such as the reading end of an io.Pipe,
+// is streamed as it's produced: closing the writer with CloseWithError aborts the upload, and the
+// returned error matches the producer's with errors.Is.
+func (c *Client) UploadFile(bucketId string, relativePath string, data io.Reader) (*FileUploadResponse, error) {
+	return c.UploadFileWithContext(context.Background(), bucketId, relativePath, data)
+}
+
+func (c *Client) UploadFileWithContext(ctx context.Context, bucketId string, relativePath string, data io.Reader) (*FileUploadResponse, error) {
+	return c.UploadFileWithOptionsWithContext(ctx, bucketId, relativePath, data, FileOptions{Upsert: defaultFileUpsert})
+}
+
+func (c *Client) MoveFile(bucketId string, sourceKey string, destinationKey string) (*FileUploadResponse, error) {
+	return c.MoveFileWithContext(context.Background(), bucketId, sourceKey, destinationKey)
+}
+
+func (c *Client) MoveFileWithContext(ctx context.Context, bucketId string, sourceKey string, destinationKey string) (*FileUploadResponse, error) {
+	return c.moveOrCopyFile(ctx, "/object/move", bucketId, sourceKey, "", destinationKey)
+}
+
+// MoveFileToBucket moves sourceKey of sourceBucket to destKey in destBucket in a single request,
+// rather than copying and deleting it
+func (c *Client) MoveFileToBucket(sourceBucket string, sourceKey string, destBucket string, destKey string) (*FileUploadResponse, error) {
+	return c.MoveFileToBucketWithContext(context.Background(), sourceBucket, sourceKey, destBucket, destKey)
+}
+
+func (c *Client) MoveFileToBucketWithContext(ctx context.Context, sourceBucket string, sourceKey string, destBucket string, destKey string) (*FileUploadResponse, error) {
+	return c.moveOrCopyFile(ctx, "/object/move", sourceBucket, sourceKey, destBucket, destKey)
+}
+
+// CopyFile duplicates sourceKey to destinationKey within the bucket, leaving the source in place
+func (c *Client) CopyFile(bucketId string, sourceKey string, destinationKey string) (*FileUploadResponse, error) {
+	return c.CopyFileWithContext(context.Background(), bucketId, sourceKey, destinationKey)
+}
+
+func (c *Client) CopyFileWithContext(ctx context.Context, bucketId string, sourceKey string, destinationKey string) (*FileUploadResponse, error) {
+	return c.moveOrCopyFile(ctx, "/object/copy", bucketId, sourceKey, "", destinationKey)
+}
+
+// CopyFileToBucket duplicates sourceKey of sourceBucket to destKey in destBucket, leaving the source in place
+func (c *Client) CopyFileToBucket(sourceBucket string, sourceKey string, destBucket string, destKey string) (*FileUploadResponse, error) {
+	return c.CopyFileToBucketWithContext(context.Background(), sourceBucket, sourceKey, destBucket, destKey)
+}
+
+func (c *Client) CopyFileToBucketWithContext(ctx context.Context, sourceBucket string, sourceKey string, destBucket string, destKey string) (*FileUploadResponse, error) {
+	return c.moveOrCopyFile(ctx, "/object/copy", sourceBucket, sourceKey, destBucket, destKey)
+}
+
+// moveOrCopyFile sends a move or copy request, both endpoints share the same body and response shape.
+// An empty destinationBucket keeps the object in bucketId.
+func (c *Client) moveOrCopyFile(ctx context.Context, endpoint string, bucketId string, sourceKey string, destinationBucket string, destinationKey string) (*FileUploadResponse, error) {
+	sourceKey, err := normalizeObjectKey(sourceKey)
+	if err != nil {
+		return nil, err
+	}
+	destinationKey, err = normalizeObjectKey(destinationKey)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyData := map[string]interface{}{
+		"bucketId":       bucketId,
+		"sourceKey":      sourceKey,
+		"destinationKey": destinationKey,
+	}
+	if destinationBucket != "" {
+		bodyData["destinationBucket"] = destinationBucket
+	}
+	jsonBody, _ := json.Marshal(bodyData)
+
+	request, err := c.newRequest(
+		ctx,
+		http.MethodPost,
+		endpoint,
+		bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+
+	if !isSuccess(res) {
+		return nil, objectError(parseErrorResponse(res))
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var response FileUploadResponse
+	err = decodeJSON(body, &response)
+	if err != nil {
+		return nil, err
+	}
+	response.StatusCode = res.StatusCode
+
+	return &response, nil
+}
+
+func (c *Client) CreateSignedUrl(bucketId string, filePath string, expiresIn int, urlOptions ...UrlOptions) (*SignedUrlResponse, error) {
+	return c.CreateSignedUrlWithContext(context.Background(), bucketId, filePath, expiresIn, urlOptions...)
+}
+
+func (c *Client) CreateSignedUrlWithContext(ctx context.Context, bucketId string, filePath string, expiresIn int, urlOptions ...UrlOptions) (*SignedUrlResponse, error) {
+	if err := validateExpiresIn(expiresIn); err != nil {
+		return nil, err
+	}
+
+	response, err := c.createSignedUrl(ctx, bucketId, filePath, map[string]interface{}{
+		"expiresIn": expiresIn,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, options := range urlOptions {
+		response.SignedURL = options.apply(response.SignedURL)
+	}
+
+	return response, nil
+}
+
+// validateExpiresIn rejects expiries the server would only refuse once the URL is used
+func validateExpiresIn(expiresIn int) error {
+	if expiresIn <= 0 {
+		return errors.New("storage: signed URL expiresIn must be a positive number of seconds")
+	}
+
+	return nil
+}
+
+// createSignedUrl signs a single object with the given request body
+func (c *Client) createSignedUrl(ctx context.Context, bucketId string, filePath string, bodyData map[string]interface{}) (*SignedUrlResponse, error) {
+	jsonBody, _ := json.Marshal(bodyData)
+	_path, err := objectPath(bucketId, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := c.newRequest(
+		ctx,
+		http.MethodPost,
+		"/object/sign/"+_path,
+		bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+
+	// The signed URL was never created, so don't hand back a half-built one
+	if !isSuccess(res) {
+		return nil, objectError(parseErrorResponse(res))
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var response SignedUrlResponse
+	err = decodeJSON(body, &response)
+	if err != nil {
+		return nil, err
+	}
+	response.SignedURL = c.urlBase() + response.SignedURL
+	response.Path = filePath
+
+	return &response, nil
+}
+
+// CreateSignedUrls signs several objects of the bucket in a single request. Each entry carries the
+// requested path, and an Error instead of a SignedURL when that object couldn't be signed.
+func (c *Client) CreateSignedUrls(bucketId string, paths []string, expiresIn int) ([]SignedUrlResponse, error) {
+	return c.CreateSignedUrlsWithContext(context.Background(), bucketId, paths, expiresIn)
+}
+
+func (c *Client) CreateSignedUrlsWithContext(ctx context.Context, bucketId string, paths []string, expiresIn int) ([]SignedUrlResponse, error) {
+	if err := validateExpiresIn(expiresIn); err != nil {
+		return nil, err
+	}
+
+	normalized := make([]string, len(paths))
+	for i, path := range paths {
+		var err error
+		if normalized[i], err = normalizeObjectKey(path); err != nil {
+			return nil, fmt.Errorf("%w (path %q)", err, path)
+		}
+	}
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{
+		"expiresIn": expiresIn,
+		"paths":     normalized,
+	})
+
+	request, err := c.newRequest(
+		ctx,
+		http.MethodPost,
+		"/object/sign/"+url.PathEscape(bucketId),
+		bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+
+	if !isSuccess(res) {
+		return nil, parseErrorResponse(res)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var response []SignedUrlResponse
+	err = decodeJSON(body, &response)
+	if err != nil {
+		return nil, err
+	}
+	for i := range response {
+		if response[i].SignedURL != "" {
+			response[i].SignedURL = c.urlBase() + response[i].SignedURL
+		}
+		// Entries come back in the order of the request, older servers don't echo the path
+		if response[i].Path == "" && i < len(paths) {
+			response[i].Path = paths[i]
+		}
+	}
+
+	return response, nil
+}
+
+// CreateSignedUrlsVarying signs each path for its own expiry. The API takes one expiry per batch, so a
+// batch request is sent per distinct expiry; the URLs are returned in the order of reqs.
+func (c *Client) CreateSignedUrlsVarying(bucketId string, reqs []SignRequest) ([]SignedUrlResponse, error) {
+	return c.CreateSignedUrlsVaryingWithContext(context.Background(), bucketId, reqs)
+}
+
+func (c *Client) CreateSignedUrlsVaryingWithContext(ctx context.Context, bucketId string, reqs []SignRequest) ([]SignedUrlResponse, error) {
+	// Groups are kept in order of first appearance so the requests sent are deterministic
+	var expiries []int
+	groups := map[int][]int{}
+	for i, req := range reqs {
+		if err := validateExpiresIn(req.ExpiresIn); err != nil {
+			return nil, fmt.Errorf("%w (path %q)", err, req.Path)
+		}
+		if _, ok := groups[req.ExpiresIn]; !ok {
+			expiries = append(expiries, req.ExpiresIn)
+		}
+		groups[req.ExpiresIn] = append(groups[req.ExpiresIn], i)
+	}
+
+	responses := make([]SignedUrlResponse, len(reqs))
+	for _, expiresIn := range expiries {
+		indexes := groups[expiresIn]
+		paths := make([]string, len(indexes))
+		for i, index := range indexes {
+			paths[i] = reqs[index].Path
+		}
+
+		signed, err := c.CreateSignedUrlsWithContext(ctx, bucketId, paths, expiresIn)
+		if err != nil {
+			return nil, err
+		}
+		for i, index := range indexes {
+			if i < len(signed) {
+				responses[index] = signed[i]
+			}
+		}
+	}
+
+	return responses, nil
+}
+
+// CreateSignedUploadUrl creates a URL and token that let a client upload the object without other credentials
+func (c *Client) CreateSignedUploadUrl(bucketId string, filePath string) (*SignedUploadUrlResponse, error) {
+	return c.CreateSignedUploadUrlWithContext(context.Background(), bucketId, filePath)
+}
+
+func (c *Client) CreateSignedUploadUrlWithContext(ctx context.Context, bucketId string, filePath string) (*SignedUploadUrlResponse, error) {
+	_path, err := objectPath(bucketId, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := c.newRequest(ctx, http.MethodPost, "/object/upload/sign/"+_path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+
+	if !isSuccess(res) {
+		return nil, uploadError(parseErrorResponse(res))
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var response SignedUploadUrlResponse
+	err = decodeJSON(body, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	// The token is only handed back as part of the relative URL
+	signedUrl, err := url.Parse(response.Url)
+	if err != nil {
+		return nil, err
+	}
+	response.Token = signedUrl.Query().Get("token")
+	response.Url = c.urlBase() + response.Url
+
+	return &response, nil
+}
+
+// UploadToSignedUrl uploads data using a token obtained from CreateSignedUploadUrl.
+// An empty contentType is inferred from the file extension.
+func (c *Client) UploadToSignedUrl(bucketId string, filePath string, token string, data io.Reader, contentType string) (*FileUploadResponse, error) {
+	return c.UploadToSignedUrlWithContext(context.Background(), bucketId, filePath, token, data, contentType)
+}
+
+func (c *Client) UploadToSignedUrlWithContext(ctx context.Context, bucketId string, filePath string, token string, data io.Reader, contentType string) (*FileUploadResponse, error) {
+	_path, err := objectPath(bucketId, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if contentType == "" {
+		contentType = detectContentType(filePath)
+	}
+
+	size := dataSize(data)
+	if err := c.checkUploadSize(size); err != nil {
+		return nil, err
+	}
+
+	request, err := c.newRequest(
+		ctx,
+		http.MethodPut,
+		"/object/upload/sign/"+_path+"?token="+url.QueryEscape(token),
+		c.limitUpload(size)(bufio.NewReader(data)))
+	if err != nil {
+		return nil, err
+	}
+	if size > 0 {
+		request.ContentLength = size
+	}
+	request.Header.Set("cache-control", defaultFileCacheControl)
+	request.Header.Set("content-type", contentType)
+
+	res, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+
+	if !isSuccess(res) {
+		return nil, uploadError(parseErrorResponse(res))
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var response FileUploadResponse
+	err = decodeJSON(body, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	response.setResponse(res)
+
+	return &response, nil
+}
+
+func (c *Client) DownloadFile(bucketId string, filePath string) ([]byte, error) {
+	return c.DownloadFileWithContext(context.Background(), bucketId, filePath)
+}
+
+func (c *Client) DownloadFileWithContext(ctx context.Context, bucketId string, filePath string) ([]byte, error) {
+	stream, err := c.DownloadFileStreamWithContext(ctx, bucketId, filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	return ioutil.ReadAll(stream)
+}
+
+// DownloadFileIfChanged downloads the object unless its ETag still matches etag, in which case
+// notModified is true and no body is returned. An empty etag always downloads the object.
+func (c *Client) DownloadFileIfChanged(bucketId string, filePath string, etag string) (body []byte, notModified bool, err error) {
+	return c.DownloadFileIfChangedWithContext(context.Background(), bucketId, filePath, etag)
+}
+
+func (c *Client) DownloadFileIfChangedWithContext(ctx context.Context, bucketId string, filePath string, etag string) (body []byte, notModified bool, err error) {
+	_path, err := objectPath(bucketId, filePath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	request, err := c.newRequest(ctx, http.MethodGet, "/object/authenticated/"+_path, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+
+	res, err := c.do(request)
+	if err != nil {
+		return nil, false, err
+	}
+	defer drainAndClose(res.Body)
+
+	if res.StatusCode == http.StatusNotModified {
+		return nil, true, nil
+	}
+	if !isSuccess(res) {
+		return nil, false, objectError(parseErrorResponse(res))
+	}
+
+	body, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return body, false, nil
+}
+
+// DownloadFileRange downloads the bytes start through end of the object, both inclusive. It fails
+// when the server ignores the range and answers with the whole object.
+func (c *Client) DownloadFileRange(bucketId string, filePath string, start int64, end int64) ([]byte, error) {
+	return c.DownloadFileRangeWithContext(context.Background(), bucketId, filePath, start, end)
+}
+
+func (c *Client) DownloadFileRangeWithContext(ctx context.Context, bucketId string, filePath string, start int64, end int64) ([]byte, error) {
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("storage: invalid byte range %d-%d", start, end)
+	}
+
+	_path, err := objectPath(bucketId, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := c.newRequest(ctx, http.MethodGet, "/object/authenticated/"+_path, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	res, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+
+	if !isSuccess(res) {
+		return nil, objectError(parseErrorResponse(res))
+	}
+	if res.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("storage: expected a partial response to the range request, got status %d", res.StatusCode)
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// DownloadPublicFile downloads an object of a public bucket through its public URL, without
+// sending the client credentials, e.g. to check the object is reachable anonymously.
+func (c *Client) DownloadPublicFile(bucketId string, filePath string) ([]byte, error) {
+	return c.DownloadPublicFileWithContext(context.Background(), bucketId, filePath)
+}
+
+func (c *Client) DownloadPublicFileWithContext(ctx context.Context, bucketId string, filePath string) ([]byte, error) {
+	_path, err := objectPath(bucketId, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := c.newRequest(ctx, http.MethodGet, "/object/public/"+_path, nil)
+	if err != nil {
+		return nil, err
+	}
+	// A header present on the request, even without values, isn't filled in from the client headers
+	request.Header["Authorization"] = nil
+	request.Header["Apikey"] = nil
+
+	stream, err := c.doDownload(request)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	return ioutil.ReadAll(stream)
+}
+
+// GetFileMetadata fetches the object's information and metadata without downloading it
+func (c *Client) GetFileMetadata(bucketId string, filePath string) (*FileObject, error) {
+	return c.GetFileMetadataWithContext(context.Background(), bucketId, filePath)
+}
+
+func (c *Client) GetFileMetadataWithContext(ctx context.Context, bucketId string, filePath string) (*FileObject, error) {
+	_path, err := objectPath(bucketId, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := c.newRequest(ctx, http.MethodGet, "/object/info/authenticated/"+_path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+
+	if !isSuccess(res) {
+		return nil, objectError(parseErrorResponse(res))
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var response FileObject
+	err = decodeJSON(body, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// UpdateFileMetadata replaces the custom metadata and cache-control of an object without re-uploading it.
+// The object is copied onto itself with the new metadata, and its updated information is returned.
+func (c *Client) UpdateFileMetadata(bucketId string, filePath string, metadata map[string]string, cacheControl string) (*FileObject, error) {
+	return c.UpdateFileMetadataWithContext(context.Background(), bucketId, filePath, metadata, cacheControl)
+}
+
+func (c *Client) UpdateFileMetadataWithContext(ctx context.Context, bucketId string, filePath string, metadata map[string]string, cacheControl string) (*FileObject, error) {
+	key, err := normalizeObjectKey(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	objectMetadata := map[string]interface{}{}
+	if cacheControl != "" {
+		objectMetadata["cacheControl"] = cacheControl
+	}
+	jsonBody, _ := json.Marshal(map[string]interface{}{
+		"bucketId":       bucketId,
+		"sourceKey":      key,
+		"destinationKey": key,
+		"copyMetadata":   false,
+		"metadata":       objectMetadata,
+	})
+
+	request, err := c.newRequest(ctx, http.MethodPost, "/object/copy", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+	// The copy replaces the object it was taken from
+	request.Header.Set("x-upsert", "true")
+	if metadata != nil {
+		request.Header.Set("x-metadata", metadataHeader(metadata))
+	}
+
+	res, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+
+	if !isSuccess(res) {
+		return nil, objectError(parseErrorResponse(res))
+	}
+
+	return c.GetFileMetadataWithContext(ctx, bucketId, key)
+}
+
+// FileExists checks whether the object exists with a HEAD request, without downloading it. It returns
+// false for a 404 and a *StorageError for any other failure.
+func (c *Client) FileExists(bucketId string, filePath string) (bool, error) {
+	return c.FileExistsWithContext(context.Background(), bucketId, filePath)
+}
 
-	var (
-		res *http.Response
-		err error
-	)
+func (c *Client) FileExistsWithContext(ctx context.Context, bucketId string, filePath string) (bool, error) {
+	_path, err := objectPath(bucketId, filePath)
+	if err != nil {
+		return false, err
+	}
 
-	if update {
-		var request *http.Request
-		request, err = http.NewRequest(http.MethodPut, c.clientTransport.baseUrl.String()+"/object/"+_path, body)
-		res, err = c.session.Do(request)
-	} else {
-		res, err = c.session.Post(
-			c.clientTransport.baseUrl.String()+"/object/"+_path,
-			defaultFileContentType,
-			body)
+	request, err := c.newRequest(ctx, http.MethodHead, "/object/authenticated/"+_path, nil)
+	if err != nil {
+		return false, err
 	}
+
+	res, err := c.do(request)
 	if err != nil {
-		panic(err)
+		return false, err
 	}
+	defer drainAndClose(res.Body)
 
-	body_, err := ioutil.ReadAll(res.Body)
-	var response FileUploadResponse
-	err = json.Unmarshal(body_, &response)
+	// HEAD responses have no body to tell the reason, so only a 404 is taken for a missing object;
+	// a 400 may as well be a bad bucket name or key and is returned as an error
+	switch {
+	case isSuccess(res):
+		return true, nil
+	case res.StatusCode == http.StatusNotFound:
+		return false, nil
+	}
 
-	return response
+	return false, &StorageError{StatusCode: res.StatusCode}
 }
 
-func (c *Client) UpdateFile(bucketId string, relativePath string, data io.Reader) FileUploadResponse {
-	return c.UploadOrUpdateFile(bucketId, relativePath, data, true)
+// DownloadFileToPath streams the object to localPath, creating missing parent directories.
+// The file is written next to localPath and only moved into place once the download completes.
+func (c *Client) DownloadFileToPath(bucketId string, filePath string, localPath string) error {
+	return c.DownloadFileToPathWithContext(context.Background(), bucketId, filePath, localPath)
 }
 
-func (c *Client) UploadFile(bucketId string, relativePath string, data io.Reader) FileUploadResponse {
-	return c.UploadOrUpdateFile(bucketId, relativePath, data, false)
+func (c *Client) DownloadFileToPathWithContext(ctx context.Context, bucketId string, filePath string, localPath string) error {
+	stream, err := c.DownloadFileStreamWithContext(ctx, bucketId, filePath)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	dir := filepath.Dir(localPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(dir, filepath.Base(localPath)+".*.part")
+	if err != nil {
+		return err
+	}
+	// Removing fails harmlessly once the file has been renamed
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, stream); err != nil {
+		file.Close()
+		return err
+	}
+	// CreateTemp makes the file private, give it the permissions os.Create would have instead
+	mode, err := createMode(localPath, file.Name()+".mode")
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Chmod(mode); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), localPath)
 }
 
-func (c *Client) MoveFile(bucketId string, sourceKey string, destinationKey string) FileUploadResponse {
-	jsonBody, _ := json.Marshal(map[string]interface{}{
-		"bucketId":       bucketId,
-		"sourceKey":      sourceKey,
-		"destinationKey": destinationKey,
-	})
+// createMode returns the permissions of the file at path, or those os.Create gives a new file when
+// there is none, found by creating the unused file probePath
+func createMode(path string, probePath string) (os.FileMode, error) {
+	if info, err := os.Stat(path); err == nil {
+		return info.Mode().Perm(), nil
+	}
 
-	request, err := http.NewRequest(
-		http.MethodPost,
-		c.clientTransport.baseUrl.String()+"/object/move",
-		bytes.NewBuffer(jsonBody))
+	probe, err := os.OpenFile(probePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(probePath)
+	defer probe.Close()
 
-	res, err := c.session.Do(request)
+	info, err := probe.Stat()
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
-	var response FileUploadResponse
-	err = json.Unmarshal(body, &response)
+	return info.Mode().Perm(), nil
+}
 
-	return response
+// DownloadFileStream returns the live response body of the object along with its content headers,
+// the caller is responsible for closing it
+func (c *Client) DownloadFileStream(bucketId string, filePath string) (*DownloadResult, error) {
+	return c.DownloadFileStreamWithContext(context.Background(), bucketId, filePath)
 }
 
-func (c *Client) CreateSignedUrl(bucketId string, filePath string, expiresIn int) SignedUrlResponse {
-	jsonBody, _ := json.Marshal(map[string]interface{}{
-		"expiresIn": expiresIn,
-	})
+func (c *Client) DownloadFileStreamWithContext(ctx context.Context, bucketId string, filePath string) (*DownloadResult, error) {
+	_path, err := objectPath(bucketId, filePath)
+	if err != nil {
+		return nil, err
+	}
 
-	request, err := http.NewRequest(
-		http.MethodPost,
-		c.clientTransport.baseUrl.String()+"/object/sign/"+bucketId+"/"+filePath,
-		bytes.NewBuffer(jsonBody))
+	return c.downloadStream(ctx, "/object/authenticated/"+_path)
+}
 
-	res, err := c.session.Do(request)
+// downloadStream GETs the API path and returns the live response body
+func (c *Client) downloadStream(ctx context.Context, path string) (*DownloadResult, error) {
+	request, err := c.newRequest(ctx, http.MethodGet, path, nil)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
-	var response SignedUrlResponse
-	err = json.Unmarshal(body, &response)
-	response.SignedURL = c.clientTransport.baseUrl.String() + response.SignedURL
+	return c.doDownload(request)
+}
 
-	return response
+// doDownload sends a download request and returns the response body of a successful one
+func (c *Client) doDownload(request *http.Request) (*DownloadResult, error) {
+	res, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// A missing object is reported as a StorageError so it can't be mistaken for an empty file
+	if !isSuccess(res) {
+		defer drainAndClose(res.Body)
+		return nil, objectError(parseErrorResponse(res))
+	}
+
+	if c.decompress {
+		if err := decompressResponse(res); err != nil {
+			drainAndClose(res.Body)
+			return nil, err
+		}
+	}
+
+	return &DownloadResult{
+		Body:          res.Body,
+		ContentType:   res.Header.Get("Content-Type"),
+		ContentLength: res.ContentLength,
+		ETag:          res.Header.Get("ETag"),
+	}, nil
 }
 
-func (c *Client) GetPublicUrl(bucketId string, filePath string) SignedUrlResponse {
+// GetPublicUrl builds the URL of an object in a public bucket. A path that is empty or escapes the
+// bucket gets no SignedURL, the reason is in Error instead.
+func (c *Client) GetPublicUrl(bucketId string, filePath string, urlOptions ...UrlOptions) SignedUrlResponse {
 	var response SignedUrlResponse
 
-	response.SignedURL = c.clientTransport.baseUrl.String() + "/object/public/" + bucketId + "/" + filePath
+	response.Path = filePath
+	key, err := normalizeObjectKey(filePath)
+	if err != nil {
+		response.Error = err.Error()
+		return response
+	}
+	response.SignedURL = c.urlBase() + "/object/public/" + escapeObjectPath(bucketId, key)
+	for _, options := range urlOptions {
+		response.SignedURL = options.apply(response.SignedURL)
+	}
 
 	return response
 }
 
-func (c *Client) RemoveFile(bucketId string, paths []string) FileUploadResponse {
+// GetPublicUrlChecked is GetPublicUrl, but fetches the bucket first and fails when it isn't public
+// rather than returning a URL that can't be served
+func (c *Client) GetPublicUrlChecked(bucketId string, filePath string, urlOptions ...UrlOptions) (*SignedUrlResponse, error) {
+	return c.GetPublicUrlCheckedWithContext(context.Background(), bucketId, filePath, urlOptions...)
+}
+
+func (c *Client) GetPublicUrlCheckedWithContext(ctx context.Context, bucketId string, filePath string, urlOptions ...UrlOptions) (*SignedUrlResponse, error) {
+	if _, err := normalizeObjectKey(filePath); err != nil {
+		return nil, err
+	}
+
+	bucket, err := c.GetBucketWithContext(ctx, bucketId)
+	if err != nil {
+		return nil, err
+	}
+	if !bucket.Public {
+		return nil, fmt.Errorf("storage: bucket %q is not public, use CreateSignedUrl instead", bucketId)
+	}
+
+	response := c.GetPublicUrl(bucketId, filePath, urlOptions...)
+	return &response, nil
+}
+
+// DeleteFiles deletes the objects with exactly the given keys, a key never matches the objects of a
+// folder it names. The deleted objects are returned, keys without an object are left out.
+func (c *Client) DeleteFiles(bucketId string, keys []string) ([]FileObject, error) {
+	return c.DeleteFilesWithContext(context.Background(), bucketId, keys)
+}
+
+func (c *Client) DeleteFilesWithContext(ctx context.Context, bucketId string, keys []string) ([]FileObject, error) {
+	normalized := make([]string, len(keys))
+	for i, key := range keys {
+		var err error
+		if normalized[i], err = normalizeObjectKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	// The API names the keys "prefixes", but it only deletes exact matches
 	jsonBody, _ := json.Marshal(map[string]interface{}{
-		"prefixes": paths,
+		"prefixes": normalized,
 	})
 
-	request, err := http.NewRequest(
+	request, err := c.newRequest(
+		ctx,
 		http.MethodDelete,
-		c.clientTransport.baseUrl.String()+"/object/"+bucketId,
+		"/object/"+url.PathEscape(bucketId),
 		bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, err
+	}
 
-	res, err := c.session.Do(request)
+	res, err := c.do(request)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+
+	if !isSuccess(res) {
+		return nil, parseErrorResponse(res)
 	}
 
 	body, err := ioutil.ReadAll(res.Body)
-	var response FileUploadResponse
-	err = json.Unmarshal(body, &response)
-	response.Data = body
+	if err != nil {
+		return nil, err
+	}
 
-	return response
+	var deleted []FileObject
+	err = decodeJSON(body, &deleted)
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// RemoveFile deletes the objects with exactly the given paths and returns the deleted objects,
+// see DeleteFiles
+func (c *Client) RemoveFile(bucketId string, paths []string) ([]FileObject, error) {
+	return c.RemoveFileWithContext(context.Background(), bucketId, paths)
+}
+
+func (c *Client) RemoveFileWithContext(ctx context.Context, bucketId string, paths []string) ([]FileObject, error) {
+	return c.DeleteFilesWithContext(ctx, bucketId, paths)
+}
+
+// RemoveFileWithOptions is RemoveFile which, with DryRun, returns the existing objects among paths
+// without deleting them
+func (c *Client) RemoveFileWithOptions(bucketId string, paths []string, options DeleteOptions) ([]FileObject, error) {
+	return c.RemoveFileWithOptionsWithContext(context.Background(), bucketId, paths, options)
+}
+
+func (c *Client) RemoveFileWithOptionsWithContext(ctx context.Context, bucketId string, paths []string, options DeleteOptions) ([]FileObject, error) {
+	if !options.DryRun {
+		return c.DeleteFilesWithContext(ctx, bucketId, paths)
+	}
+
+	keys := make([]string, len(paths))
+	for i, key := range paths {
+		var err error
+		if keys[i], err = normalizeObjectKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.findObjects(ctx, bucketId, keys)
+}
+
+func (c *Client) ListFiles(bucketId string, queryPath string, options FileSearchOptions) ([]FileObject, error) {
+	return c.ListFilesWithContext(context.Background(), bucketId, queryPath, options)
 }
 
-func (c *Client) ListFiles(bucketId string, queryPath string, options FileSearchOptions) []FileObject {
+func (c *Client) ListFilesWithContext(ctx context.Context, bucketId string, queryPath string, options FileSearchOptions) ([]FileObject, error) {
 	if options.Offset == 0 {
 		options.Offset = defaultOffset
 	}
@@ -158,6 +1148,10 @@ func (c *Client) ListFiles(bucketId string, queryPath string, options FileSearch
 		options.SortByOptions.Column = defaultSortColumn
 	}
 
+	if err := options.SortByOptions.validate(); err != nil {
+		return nil, err
+	}
+
 	body_ := ListFileRequestBody{
 		Limit:  options.Limit,
 		Offset: options.Offset,
@@ -166,69 +1160,599 @@ func (c *Client) ListFiles(bucketId string, queryPath string, options FileSearch
 			Order:  options.SortByOptions.Order,
 		},
 		Prefix: queryPath,
+		Search: options.Search,
 	}
 	jsonBody, _ := json.Marshal(body_)
 
-	request, err := http.NewRequest(
+	request, err := c.newRequest(
+		ctx,
 		http.MethodPost,
-		c.clientTransport.baseUrl.String()+"/object/list/"+bucketId,
+		"/object/list/"+url.PathEscape(bucketId),
 		bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, err
+	}
 
-	res, err := c.session.Do(request)
+	res, err := c.do(request)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+
+	if !isSuccess(res) {
+		return nil, parseErrorResponse(res)
 	}
 
 	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
 	var response []FileObject
+	err = decodeJSON(body, &response)
+	if err != nil {
+		// The API answered with an error object instead of the expected array
+		if len(bytes.TrimSpace(body)) > 0 && bytes.TrimSpace(body)[0] == '{' {
+			return nil, newStorageError(res.StatusCode, body)
+		}
+		return nil, err
+	}
 
-	err = json.Unmarshal(body, &response)
+	return response, nil
+}
 
-	return response
+// ListFilesAll pages through the listing, starting at options.Offset, until a page comes back with
+// fewer than options.Limit entries and returns all of them
+func (c *Client) ListFilesAll(bucketId string, queryPath string, options FileSearchOptions) ([]FileObject, error) {
+	return c.ListFilesAllWithContext(context.Background(), bucketId, queryPath, options)
+}
+
+func (c *Client) ListFilesAllWithContext(ctx context.Context, bucketId string, queryPath string, options FileSearchOptions) ([]FileObject, error) {
+	var files []FileObject
+	err := c.ListFilesFuncWithContext(ctx, bucketId, queryPath, options, func(file FileObject) error {
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// ListFilesFunc pages through the listing like ListFilesAll, but calls fn with every entry instead of
+// collecting them, so only a single page is held in memory. It stops at the first error fn returns,
+// and returns that error.
+func (c *Client) ListFilesFunc(bucketId string, queryPath string, options FileSearchOptions, fn func(FileObject) error) error {
+	return c.ListFilesFuncWithContext(context.Background(), bucketId, queryPath, options, fn)
+}
+
+func (c *Client) ListFilesFuncWithContext(ctx context.Context, bucketId string, queryPath string, options FileSearchOptions, fn func(FileObject) error) error {
+	if options.Limit == 0 {
+		options.Limit = defaultLimit
+	}
+
+	for {
+		page, err := c.ListFilesWithContext(ctx, bucketId, queryPath, options)
+		if err != nil {
+			return err
+		}
+		for _, file := range page {
+			if err := fn(file); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < options.Limit {
+			return nil
+		}
+		options.Offset += len(page)
+	}
+}
+
+// metadataHeader encodes custom object metadata the way the x-metadata header carries it
+func metadataHeader(metadata map[string]string) string {
+	encoded, _ := json.Marshal(metadata)
+	return base64.StdEncoding.EncodeToString(encoded)
 }
 
-// removeEmptyFolderName replaces occurances of double slashes (//)  with a single slash /
-// returns a path string with all double slashes replaced with single slash /
+// responseBuffers are reused to read upload responses, which are small and frequent
+var responseBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// decodeResponse reads the JSON response body into v through a pooled buffer
+func decodeResponse(body io.Reader, v interface{}) error {
+	buffer := responseBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		buffer.Reset()
+		responseBuffers.Put(buffer)
+	}()
+
+	if _, err := buffer.ReadFrom(body); err != nil {
+		return err
+	}
+
+	return decodeJSON(buffer.Bytes(), v)
+}
+
+// decodeJSON unmarshals a successful response body into v. An empty body, e.g. of a 204 No Content,
+// leaves v untouched rather than failing with "unexpected end of JSON input".
+func decodeJSON(body []byte, v interface{}) error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// detectContentType infers the content type from the extension of filePath,
+// falling back to the default upload content type when the extension is unknown
+func detectContentType(filePath string) string {
+	if contentType := mime.TypeByExtension(path.Ext(filePath)); contentType != "" {
+		return contentType
+	}
+
+	return defaultFileContentType
+}
+
+// dataSize returns the number of bytes left in data, or -1 when the reader doesn't tell
+func dataSize(data io.Reader) int64 {
+	if sized, ok := data.(interface{ Len() int }); ok {
+		return int64(sized.Len())
+	}
+
+	if seeker, ok := data.(io.Seeker); ok {
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		end, err := seeker.Seek(0, io.SeekEnd)
+		if err != nil {
+			return -1
+		}
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return -1
+		}
+		return end - offset
+	}
+
+	return -1
+}
+
+// preservedMetadata returns the custom metadata of the existing object at relativePath with metadata
+// merged over it, metadata alone when there's no such object
+func (c *Client) preservedMetadata(ctx context.Context, bucketId string, relativePath string, metadata map[string]string) (map[string]string, error) {
+	existing, err := c.GetFileMetadataWithContext(ctx, bucketId, relativePath)
+	if errors.Is(err, ErrObjectNotFound) {
+		return metadata, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(existing.UserMetadata) == 0 {
+		return metadata, nil
+	}
+
+	merged := make(map[string]string, len(existing.UserMetadata)+len(metadata))
+	for key, value := range existing.UserMetadata {
+		if text, ok := value.(string); ok {
+			merged[key] = text
+			continue
+		}
+		// Values set by other clients may not be strings, they're kept as their JSON
+		encoded, _ := json.Marshal(value)
+		merged[key] = string(encoded)
+	}
+	for key, value := range metadata {
+		merged[key] = value
+	}
+
+	return merged, nil
+}
+
+// checkUploadSize rejects an upload of known size over the client's limit, -1 is an unknown size
+func (c *Client) checkUploadSize(size int64) error {
+	if c.maxUploadSize > 0 && size > c.maxUploadSize {
+		return fmt.Errorf("%w: %d bytes over the limit of %d", ErrUploadTooLarge, size, c.maxUploadSize)
+	}
+
+	return nil
+}
+
+// limitUpload returns the wrapper enforcing the client's limit on an upload body of the given size,
+// only bodies of unknown size need it since the others are checked up front
+func (c *Client) limitUpload(size int64) func(io.Reader) io.Reader {
+	if c.maxUploadSize <= 0 || size >= 0 {
+		return func(body io.Reader) io.Reader { return body }
+	}
+
+	return func(body io.Reader) io.Reader {
+		return &maxSizeReader{reader: body, remaining: c.maxUploadSize}
+	}
+}
+
+// maxSizeReader fails with ErrUploadTooLarge once more than remaining bytes are read through it
+type maxSizeReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *maxSizeReader) Read(p []byte) (int, error) {
+	// One byte past the limit is enough to tell the body is too large
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.reader.Read(p)
+	if int64(n) > r.remaining {
+		n = int(r.remaining)
+		r.remaining = 0
+		return n, ErrUploadTooLarge
+	}
+	r.remaining -= int64(n)
+	return n, err
+}
+
+// multipartBody streams file as the "file" part of a multipart form with the given boundary, after
+// the cacheControl and, when given, metadata and contentDisposition fields.
+// The form is written from a goroutine, closing the returned reader stops it.
+func multipartBody(file io.Reader, boundary string, fileName string, contentType string, cacheControl string, metadata map[string]string, contentDisposition string) io.ReadCloser {
+	reader, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	form.SetBoundary(boundary)
+
+	go func() {
+		err := form.WriteField("cacheControl", cacheControl)
+		if err == nil && metadata != nil {
+			// The form field carries the plain JSON, only the header needs it base64 encoded
+			encoded, _ := json.Marshal(metadata)
+			err = form.WriteField("metadata", string(encoded))
+		}
+		if err == nil && contentDisposition != "" {
+			err = form.WriteField("contentDisposition", contentDisposition)
+		}
+		if err == nil {
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{
+				"name":     "file",
+				"filename": fileName,
+			}))
+			header.Set("Content-Type", contentType)
+
+			var part io.Writer
+			if part, err = form.CreatePart(header); err == nil {
+				_, err = io.Copy(part, file)
+			}
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	return reader
+}
+
+// progressReader reports the bytes read through it to onProgress
+type progressReader struct {
+	reader     io.Reader
+	sent       int64
+	total      int64
+	onProgress func(bytesSent int64, totalBytes int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.sent += int64(n)
+		r.onProgress(r.sent, r.total)
+	}
+	return n, err
+}
+
+// normalizeObjectKey trims the leading slash and empty folders off key, and rejects keys escaping their bucket
+func normalizeObjectKey(key string) (string, error) {
+	key = strings.TrimPrefix(removeEmptyFolderName(key), "/")
+	if key == "" {
+		return "", errors.New("storage: object path must not be empty")
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return "", errors.New("storage: object path must not contain .. segments")
+		}
+	}
+
+	return key, nil
+}
+
+// objectPath returns the escaped "bucketId/key" path of an object, for use in request URLs
+func objectPath(bucketId string, key string) (string, error) {
+	key, err := normalizeObjectKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return escapeObjectPath(bucketId, key), nil
+}
+
+// escapeObjectPath escapes the bucket and every segment of key, keeping the slashes between them
+func escapeObjectPath(bucketId string, key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return url.PathEscape(bucketId) + "/" + strings.Join(segments, "/")
+}
+
+// removeEmptyFolderName collapses every run of slashes in filePath into a single slash /
+// returns a path string without empty folder names, leading and trailing slashes are kept
 func removeEmptyFolderName(filePath string) string {
-	return regexp.MustCompile(`\/\/`).ReplaceAllString(filePath, "/")
+	if !strings.Contains(filePath, "//") {
+		return filePath
+	}
+
+	var cleaned strings.Builder
+	cleaned.Grow(len(filePath))
+	for i := 0; i < len(filePath); i++ {
+		if filePath[i] == '/' && i > 0 && filePath[i-1] == '/' {
+			continue
+		}
+		cleaned.WriteByte(filePath[i])
+	}
+
+	return cleaned.String()
 }
 
+// Sort orders of SortBy.Order
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+// Columns files can be sorted by in SortBy.Column. The server can't sort by size or other metadata,
+// sort the listed files client side for those.
+const (
+	SortByName           = "name"
+	SortByCreatedAt      = "created_at"
+	SortByUpdatedAt      = "updated_at"
+	SortByLastAccessedAt = "last_accessed_at"
+)
+
+// SortBy orders a listing, the server defaults apply to the fields left empty
 type SortBy struct {
-	Column string `json:"column"`
-	Order  string `json:"order"`
+	Column string `json:"column,omitempty"`
+	Order  string `json:"order,omitempty"`
+}
+
+// validate rejects sort orders and columns the server doesn't know
+func (s SortBy) validate() error {
+	switch s.Order {
+	case SortAsc, SortDesc:
+	default:
+		return fmt.Errorf("storage: invalid sort order %q, expected %q or %q", s.Order, SortAsc, SortDesc)
+	}
+
+	switch s.Column {
+	case SortByName, SortByCreatedAt, SortByUpdatedAt, SortByLastAccessedAt:
+	default:
+		return fmt.Errorf("storage: invalid sort column %q, expected one of %s", s.Column,
+			strings.Join([]string{SortByName, SortByCreatedAt, SortByUpdatedAt, SortByLastAccessedAt}, ", "))
+	}
+
+	return nil
 }
 
 type FileUploadResponse struct {
+	Id      string `json:"Id"`
 	Key     string `json:"Key"`
 	Message string `json:"message"`
-	Data    []byte
+	// StatusCode is the HTTP status the server answered with, e.g. 200 or 201
+	StatusCode int `json:"-"`
+	// ETag and Version come from the upload response headers, they are empty when the server doesn't send them
+	ETag    string `json:"-"`
+	Version string `json:"-"`
+	// Data held the raw body of RemoveFile responses.
+	//
+	// Deprecated: it is no longer filled, RemoveFile returns the deleted objects instead.
+	Data []byte
+}
+
+// setResponse fills the fields of an upload response that aren't part of its body
+func (r *FileUploadResponse) setResponse(res *http.Response) {
+	r.StatusCode = res.StatusCode
+	r.ETag = res.Header.Get("ETag")
+	r.Version = res.Header.Get("x-amz-version-id")
+}
+
+// DownloadResult is a streamed download, reading and closing it reads and closes its Body
+type DownloadResult struct {
+	Body        io.ReadCloser
+	ContentType string
+	// ContentLength is -1 when the server didn't send it
+	ContentLength int64
+	ETag          string
+}
+
+func (r *DownloadResult) Read(p []byte) (int, error) {
+	return r.Body.Read(p)
+}
+
+func (r *DownloadResult) Close() error {
+	return r.Body.Close()
+}
+
+// FileOptions configures a single upload
+type FileOptions struct {
+	// ContentType is sent as is, when empty it's inferred from the file extension
+	ContentType string
+	// Upsert overwrites an existing object instead of failing
+	Upsert bool
+	// Metadata is stored as the custom metadata of the object, it's listed back in FileObject.UserMetadata
+	Metadata map[string]string
+	// CacheControl is sent verbatim, e.g. "public, max-age=31536000, immutable". A bare number
+	// of seconds is read by the server as a max-age, and it defaults to 3600 when empty.
+	CacheControl string
+	// DetectContentType sniffs the content type from the first bytes of the data when
+	// ContentType is empty and the file extension is unknown
+	DetectContentType bool
+	// OnProgress is called as the data is sent with the bytes sent so far and the total size,
+	// which is -1 when it can't be determined from the reader
+	OnProgress func(bytesSent int64, totalBytes int64)
+	// UseMultipart sends the data as a multipart/form-data "file" part instead of the raw request body
+	UseMultipart bool
+	// PreserveMetadata keeps the custom metadata of the object an upsert or update replaces, merged
+	// under Metadata. The server drops it otherwise, so it's read back before uploading. The read and
+	// the upload aren't atomic, metadata another client sets in between is lost.
+	PreserveMetadata bool
+	// ContentDisposition is sent as the Content-Disposition of the object, e.g.
+	// mime.FormatMediaType("attachment", map[string]string{"filename": "report.pdf"}), for the server
+	// to serve it on downloads instead of the storage key based name. Multipart uploads carry it as
+	// the contentDisposition form field as well.
+	ContentDisposition string
+	// Compress gzips the data on the fly and sends it with Content-Encoding: gzip, the object is
+	// stored compressed. Use WithDecompression to read such objects back decompressed.
+	Compress bool
+	// IdempotencyKey is sent as the Idempotency-Key header for the server to dedupe retried uploads.
+	// It also makes WithRetry retry the upload, which it otherwise doesn't for new objects.
+	IdempotencyKey string
+	// Headers are added to the upload request, overriding both the client headers and the ones
+	// this package sets (cache-control, content-type, x-upsert)
+	Headers map[string]string
+}
+
+// UrlOptions configures the URLs built by GetPublicUrl and CreateSignedUrl
+type UrlOptions struct {
+	// Download makes browsers save the object as an attachment instead of displaying it
+	Download bool
+	// DownloadName is the file name to save the object as, the server picks the object name when empty
+	DownloadName string
+}
+
+// apply adds the download query parameter to rawUrl when requested
+func (o UrlOptions) apply(rawUrl string) string {
+	if !o.Download {
+		return rawUrl
+	}
+
+	separator := "?"
+	if strings.Contains(rawUrl, "?") {
+		separator = "&"
+	}
+
+	return rawUrl + separator + "download=" + url.QueryEscape(o.DownloadName)
 }
 
 type SignedUrlResponse struct {
 	SignedURL string `json:"signedURL"`
+	// Path is the object path the URL was requested for, relative to the bucket
+	Path  string `json:"path"`
+	Error string `json:"error"`
+	// Transform is the transformation a render URL serves, it's nil for URLs of the original object
+	Transform *TransformOptions `json:"-"`
+}
+
+// SignRequest is a path to sign with CreateSignedUrlsVarying, valid for ExpiresIn seconds
+type SignRequest struct {
+	Path      string
+	ExpiresIn int
+}
+
+type SignedUploadUrlResponse struct {
+	Url   string `json:"url"`
+	Token string `json:"token"`
 }
 
 type FileSearchOptions struct {
-	Limit         int    `json:"limit"`
-	Offset        int    `json:"offset"`
+	Limit         int    `json:"limit,omitempty"`
+	Offset        int    `json:"offset,omitempty"`
 	SortByOptions SortBy `json:"sortBy"`
+	// Search only lists the files whose name contains it
+	Search string `json:"search,omitempty"`
 }
 
 type FileObject struct {
-	Name           string      `json:"name"`
-	BucketId       string      `json:"bucket_id"`
-	Owner          string      `json:"owner"`
-	Id             string      `json:"id"`
-	UpdatedAt      string      `json:"updated_at"`
-	CreatedAt      string      `json:"created_at"`
-	LastAccessedAt string      `json:"last_accessed_at"`
-	Metadata       interface{} `json:"metadata"`
-	Buckets        Bucket
+	Name           string         `json:"name"`
+	BucketId       string         `json:"bucket_id"`
+	Owner          string         `json:"owner"`
+	Id             string         `json:"id"`
+	UpdatedAt      string         `json:"updated_at"`
+	CreatedAt      string         `json:"created_at"`
+	LastAccessedAt string         `json:"last_accessed_at"`
+	Metadata       ObjectMetadata `json:"metadata"`
+	// UserMetadata is the custom metadata given with FileOptions.Metadata on upload
+	UserMetadata map[string]interface{} `json:"user_metadata"`
+	Buckets      Bucket                 `json:"buckets"`
+}
+
+// ObjectMetadata is the metadata the API keeps about an object, folders in a listing have none
+type ObjectMetadata struct {
+	Size         int64  `json:"size"`
+	MimeType     string `json:"mimetype"`
+	ETag         string `json:"eTag"`
+	CacheControl string `json:"cacheControl"`
+	LastModified string `json:"lastModified"`
+	// Raw holds every metadata field as sent by the API, including the ones without a typed field
+	Raw map[string]interface{} `json:"-"`
+}
+
+func (m *ObjectMetadata) UnmarshalJSON(data []byte) error {
+	// plain drops the UnmarshalJSON method so the typed fields are decoded as usual
+	type plain ObjectMetadata
+	if err := json.Unmarshal(data, (*plain)(m)); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &m.Raw)
+}
+
+// CreatedTime returns CreatedAt parsed, or the zero time if it can't be parsed
+func (f FileObject) CreatedTime() time.Time {
+	t, _ := ParseTimestamp(f.CreatedAt)
+	return t
+}
+
+// UpdatedTime returns UpdatedAt parsed, or the zero time if it can't be parsed
+func (f FileObject) UpdatedTime() time.Time {
+	t, _ := ParseTimestamp(f.UpdatedAt)
+	return t
+}
+
+// LastAccessedTime returns LastAccessedAt parsed, or the zero time if it can't be parsed
+func (f FileObject) LastAccessedTime() time.Time {
+	t, _ := ParseTimestamp(f.LastAccessedAt)
+	return t
+}
+
+// timestampLayouts are the formats the API uses for timestamps, fractional seconds are accepted by all of them
+var timestampLayouts = []string{
+	time.RFC3339,
+	// Postgres short offset, e.g. 2023-01-02T15:04:05.123456+00
+	"2006-01-02T15:04:05Z07",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05Z07",
+}
+
+// ParseTimestamp parses a timestamp returned by the API, either RFC 3339 or the Postgres
+// format with a space separator and/or a short "+00" offset
+func ParseTimestamp(value string) (time.Time, error) {
+	var err error
+	for _, layout := range timestampLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, err
 }
 
+// ListFileRequestBody is the list request body, shaped like the one the Supabase dashboard sends:
+// limit, offset, sortBy and prefix are always set, search only when given
 type ListFileRequestBody struct {
 	Limit         int    `json:"limit"`
 	Offset        int    `json:"offset"`
 	SortByOptions SortBy `json:"sortBy"`
 	Prefix        string `json:"prefix"`
+	Search        string `json:"search,omitempty"`
 }
@@ -0,0 +1,65 @@
+package test
+
+import (
+	"bytes"
+	"github.com/supabase-community/storage-go"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func BenchmarkUploadSmallFiles(b *testing.B) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{"Id":"0d7a0f4e-6d0b-4b8b-9d0b-3c6c0f8b1a2e","Key":"test1/small.txt"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	data := bytes.Repeat([]byte("a"), 1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.UploadFile("test1", "small.txt", bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkConcurrentUploads compares a WithTransportConfig pool to http.DefaultTransport, which
+// keeps only 2 idle connections per host and redials under concurrent load
+func BenchmarkConcurrentUploads(b *testing.B) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{"Key":"test1/small.txt"}`))
+	}))
+	defer server.Close()
+
+	clients := []struct {
+		name   string
+		client *storage_go.Client
+	}{
+		{"DefaultTransport", storage_go.NewClient(server.URL, token, map[string]string{},
+			storage_go.WithHTTPClient(&http.Client{Transport: http.DefaultTransport}))},
+		{"Pooled", storage_go.NewClient(server.URL, token, map[string]string{},
+			storage_go.WithTransportConfig(64, 64, time.Minute))},
+	}
+	data := bytes.Repeat([]byte("a"), 1024)
+	for _, bc := range clients {
+		b.Run(bc.name, func(b *testing.B) {
+			b.SetParallelism(16)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := bc.client.UploadFile("test1", "small.txt", bytes.NewReader(data)); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,701 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/supabase-community/storage-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	calls := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls[r.Method]++
+		if calls[r.Method] < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"Key":"test1/test.txt"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{}, storage_go.WithRetry(3, time.Millisecond))
+
+	// PUT is idempotent and the strings.Reader can be rewound
+	if _, err := c.UpdateFile("test1", "test.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if calls[http.MethodPut] != 3 {
+		t.Errorf("expected 3 PUT attempts, got %d", calls[http.MethodPut])
+	}
+
+	// POST is never retried
+	if _, err := c.UploadFile("test1", "test.txt", strings.NewReader("hello")); err == nil {
+		t.Errorf("expected the 503 to be returned")
+	}
+	if calls[http.MethodPost] != 1 {
+		t.Errorf("expected 1 POST attempt, got %d", calls[http.MethodPost])
+	}
+}
+
+func TestRetryAfterCapped(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{},
+		storage_go.WithRetry(1, time.Millisecond), storage_go.WithRateLimitRetry(1, 50*time.Millisecond))
+
+	start := time.Now()
+	if _, err := c.DownloadFile("test1", "test.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 attempts, got %d", calls)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the Retry-After to be capped, waited %v", elapsed)
+	}
+}
+
+func TestRateLimitRetry(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	// Uploads are retried once by default, when their body can be replayed
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	if _, err := c.UploadFile("test1", "test.txt", strings.NewReader("hello")); err == nil {
+		t.Error("expected the 429 to be returned")
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 attempts, got %d", calls)
+	}
+
+	calls = 0
+	if _, err := c.UploadFile("test1", "test.txt", io.MultiReader(strings.NewReader("hello"))); err == nil {
+		t.Error("expected the 429 to be returned")
+	}
+	if calls != 1 {
+		t.Errorf("expected a single attempt of a body that can't be replayed, got %d", calls)
+	}
+
+	calls = 0
+	c = storage_go.NewClient(server.URL, token, map[string]string{}, storage_go.WithRateLimitRetry(3, time.Second))
+	if _, err := c.ListBuckets(); err == nil {
+		t.Error("expected the 429 to be returned")
+	}
+	if calls != 4 {
+		t.Errorf("expected 4 attempts, got %d", calls)
+	}
+
+	calls = 0
+	c = storage_go.NewClient(server.URL, token, map[string]string{}, storage_go.WithRateLimitRetry(0, 0))
+	if _, err := c.ListBuckets(); err == nil {
+		t.Error("expected the 429 to be returned")
+	}
+	if calls != 1 {
+		t.Errorf("expected a single attempt with rate limit retries disabled, got %d", calls)
+	}
+}
+
+type countingTransport struct {
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+// trackingTransport counts the response bodies that haven't been closed yet
+type trackingTransport struct {
+	mu   sync.Mutex
+	open map[*trackedBody]string
+}
+
+type trackedBody struct {
+	io.ReadCloser
+	transport *trackingTransport
+}
+
+func (b *trackedBody) Close() error {
+	b.transport.mu.Lock()
+	delete(b.transport.open, b)
+	b.transport.mu.Unlock()
+	return b.ReadCloser.Close()
+}
+
+func (t *trackingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	res, err := http.DefaultTransport.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	body := &trackedBody{ReadCloser: res.Body, transport: t}
+	t.mu.Lock()
+	t.open[body] = request.Method + " " + request.URL.Path
+	t.mu.Unlock()
+	res.Body = body
+	return res, nil
+}
+
+func TestResponseBodiesClosed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/missing"):
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"statusCode":"404","error":"Not found","message":"Object not found"}`))
+		case strings.HasPrefix(r.URL.Path, "/object/list/"), r.URL.Path == "/bucket", r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/object/"):
+			w.Write([]byte(`[]`))
+		case strings.HasPrefix(r.URL.Path, "/object/sign/"):
+			w.Write([]byte(`{"signedURL":"/object/sign/test1/a.txt?token=a"}`))
+		default:
+			w.Write([]byte(`{"Key":"test1/a.txt","id":"test1","name":"test1"}`))
+		}
+	}))
+	defer server.Close()
+
+	tracker := &trackingTransport{open: map[*trackedBody]string{}}
+	c := storage_go.NewClient(server.URL, token, map[string]string{}, storage_go.WithHTTPClient(&http.Client{Transport: tracker}))
+	missing := storage_go.NewClient(server.URL+"/missing", token, map[string]string{}, storage_go.WithHTTPClient(&http.Client{Transport: tracker}))
+	for _, client := range []*storage_go.Client{c, missing} {
+		client.UploadFile("test1", "a.txt", strings.NewReader("hello"))
+		client.UpdateFile("test1", "a.txt", strings.NewReader("hello"))
+		client.ListFiles("test1", "", storage_go.FileSearchOptions{})
+		client.MoveFile("test1", "a.txt", "b.txt")
+		client.CopyFile("test1", "a.txt", "b.txt")
+		client.CreateSignedUrl("test1", "a.txt", 60)
+		client.CreateSignedUrls("test1", []string{"a.txt"}, 60)
+		client.CreateSignedUploadUrl("test1", "a.txt")
+		client.UploadToSignedUrl("test1", "a.txt", "token", strings.NewReader("hello"), "")
+		client.RemoveFile("test1", []string{"a.txt"})
+		client.DownloadFile("test1", "a.txt")
+		client.GetFileMetadata("test1", "a.txt")
+		client.FileExists("test1", "a.txt")
+		client.ListBuckets()
+		client.GetBucket("test1")
+		client.CreateBucket("test1", storage_go.BucketOptions{})
+		client.UpdateBucket("test1", storage_go.BucketOptions{})
+		client.EmptyBucket("test1")
+		client.DeleteBucket("test1")
+	}
+
+	for _, request := range tracker.open {
+		t.Errorf("response body of %s was left open", request)
+	}
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	counter := &countingTransport{}
+	c := storage_go.NewClient(server.URL, "secret", map[string]string{},
+		storage_go.WithHTTPClient(&http.Client{Transport: counter, Timeout: time.Second}))
+
+	if _, err := c.ListBuckets(); err != nil {
+		t.Fatal(err)
+	}
+	if counter.requests != 1 {
+		t.Errorf("expected the custom transport to send 1 request, got %d", counter.requests)
+	}
+	if authorization != "Bearer secret" {
+		t.Errorf("client headers were not applied, got authorization %q", authorization)
+	}
+
+	// A nil client keeps the default one rather than panicking
+	c = storage_go.NewClient(server.URL, "secret", map[string]string{}, storage_go.WithHTTPClient(nil))
+	if _, err := c.ListBuckets(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(300 * time.Millisecond):
+		}
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{}, storage_go.WithTimeout(50*time.Millisecond))
+
+	start := time.Now()
+	_, err := c.UploadFile("test1", "test.txt", strings.NewReader("hello"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("upload was not aborted by the timeout, took %s", elapsed)
+	}
+}
+
+func TestWithHeader(t *testing.T) {
+	headers := map[string]http.Header{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers[r.Method] = r.Header.Clone()
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`[]`))
+			return
+		}
+		w.Write([]byte(`{"Key":"test1/test.txt"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, "secret", map[string]string{},
+		storage_go.WithHeader("X-Tenant-ID", "tenant-1"),
+		storage_go.WithHeaders(map[string]string{
+			"Authorization": "Bearer other",
+			"Content-Type":  "application/xml",
+		}))
+
+	if _, err := c.ListBuckets(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UploadFile("test1", "test.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	for method, header := range headers {
+		if header.Get("X-Tenant-ID") != "tenant-1" {
+			t.Errorf("%s: missing the custom header", method)
+		}
+		if header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("%s: the auth header was clobbered, got %q", method, header.Get("Authorization"))
+		}
+	}
+	if got := headers[http.MethodPost].Values("Content-Type"); len(got) != 1 || got[0] != "text/plain; charset=utf-8" {
+		t.Errorf("the upload content type was clobbered, got %v", got)
+	}
+}
+
+func TestWithUserAgent(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	if _, err := c.ListBuckets(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(userAgent, "storage-go/") {
+		t.Errorf("expected the default user agent, got %q", userAgent)
+	}
+
+	c = storage_go.NewClient(server.URL, token, map[string]string{}, storage_go.WithUserAgent("my-app/1.0"))
+	if _, err := c.ListBuckets(); err != nil {
+		t.Fatal(err)
+	}
+	if userAgent != "my-app/1.0" {
+		t.Errorf("expected the custom user agent, got %q", userAgent)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"statusCode":"404","error":"Not found","message":"Bucket not found"}`))
+	}))
+	defer server.Close()
+
+	var (
+		authorization string
+		status        int
+		logs          bytes.Buffer
+	)
+	c := storage_go.NewClient(server.URL, "secret", map[string]string{},
+		storage_go.WithRequestHook(func(r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+		}),
+		storage_go.WithResponseHook(func(res *http.Response, err error) {
+			status = res.StatusCode
+		}),
+		storage_go.WithLogger(log.New(&logs, "", 0)))
+
+	if _, err := c.GetBucket("missing"); err == nil {
+		t.Fatal("expected the 404 to be returned")
+	}
+
+	if authorization != "REDACTED" {
+		t.Errorf("expected the auth header to be redacted, got %q", authorization)
+	}
+	if status != http.StatusNotFound {
+		t.Errorf("expected the response hook to see the 404, got %d", status)
+	}
+	if !strings.Contains(logs.String(), "GET "+server.URL+"/bucket/missing 404") || strings.Contains(logs.String(), "secret") {
+		t.Errorf("unexpected log %q", logs.String())
+	}
+}
+
+func TestNewClientWithError(t *testing.T) {
+	for _, rawUrl := range []string{"", "abc.supabase.co/storage/v1", "ftp://abc.supabase.co", "https://", "http://[::1"} {
+		if _, err := storage_go.NewClientWithError(rawUrl, "", map[string]string{}); err == nil {
+			t.Errorf("%q: expected the base URL to be rejected", rawUrl)
+		}
+
+		// The plain constructor reports the same error on use instead of panicking
+		if _, err := storage_go.NewClient(rawUrl, "", map[string]string{}).ListBuckets(); err == nil {
+			t.Errorf("%q: expected requests to fail", rawUrl)
+		}
+	}
+
+	c, err := storage_go.NewClientWithError("https://abc.supabase.co/storage/v1", "", map[string]string{})
+	if err != nil || c == nil {
+		t.Fatalf("expected a client, got %v", err)
+	}
+
+	// An invalid option is reported too, and a later valid one doesn't clear it
+	if _, err := storage_go.NewClientWithError("https://abc.supabase.co", "", map[string]string{},
+		storage_go.WithBaseURL("ftp://abc.supabase.co"), storage_go.WithBaseURL("https://abc.supabase.co")); err == nil {
+		t.Error("expected the invalid WithBaseURL to be reported")
+	}
+}
+
+func TestAnonymousClient(t *testing.T) {
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Clone())
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	anonymous := storage_go.NewClient(server.URL, "", map[string]string{})
+	if _, err := anonymous.DownloadPublicFile("test1", "test.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := anonymous.DownloadFile("test1", "test.txt"); err != nil {
+		t.Fatal(err)
+	}
+	for _, header := range headers {
+		if _, ok := header["Authorization"]; ok {
+			t.Errorf("expected no authorization header, got %q", header.Get("Authorization"))
+		}
+	}
+
+	headers = nil
+	if _, err := storage_go.NewClient(server.URL, "secret", map[string]string{}).DownloadFile("test1", "test.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if headers[0].Get("Authorization") != "Bearer secret" {
+		t.Errorf("expected the bearer token, got %q", headers[0].Get("Authorization"))
+	}
+}
+
+func TestSetAuthToken(t *testing.T) {
+	var mu sync.Mutex
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.Write([]byte(`{"Key":"test1/test.txt"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, "old", map[string]string{})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.UploadFile("test1", "test.txt", strings.NewReader("hello"))
+		}()
+	}
+	c.SetAuthToken("new")
+	wg.Wait()
+
+	for _, authorization := range authorizations {
+		if authorization != "Bearer old" && authorization != "Bearer new" {
+			t.Errorf("unexpected authorization %q", authorization)
+		}
+	}
+
+	authorizations = nil
+	if _, err := c.UploadFile("test1", "test.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if authorizations[0] != "Bearer new" {
+		t.Errorf("expected the rotated token, got %q", authorizations[0])
+	}
+
+	// The token wins over a static Authorization header, which public downloads still drop
+	c = storage_go.NewClient(server.URL, "old", map[string]string{"Authorization": "Bearer static"})
+	c.SetAuthToken("new")
+	authorizations = nil
+	if _, err := c.UploadFile("test1", "test.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DownloadPublicFile("test1", "test.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if authorizations[0] != "Bearer new" || authorizations[1] != "" {
+		t.Errorf("unexpected authorizations %q", authorizations)
+	}
+}
+
+func TestWithTracerProvider(t *testing.T) {
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+		if strings.HasPrefix(r.URL.Path, "/storage/v1/object/list/") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"statusCode":"400","error":"Bad Request","message":"invalid"}`))
+			return
+		}
+		w.Write([]byte(`{"Key":"test1/test.txt"}`))
+	}))
+	defer server.Close()
+
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	// The operation is named after the path relative to the base URL
+	recorder := tracetest.NewSpanRecorder()
+	c := storage_go.NewClient(server.URL+"/storage/v1", token, map[string]string{},
+		storage_go.WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))))
+
+	if _, err := c.UploadFile("test1", "test.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if traceparent == "" {
+		t.Error("expected the trace context to be propagated")
+	}
+	if _, err := c.ListFiles("test1", "", storage_go.FileSearchOptions{}); err == nil {
+		t.Fatal("expected the listing to fail")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 || spans[0].Name() != "storage.upload" || spans[1].Name() != "storage.list" {
+		t.Fatalf("unexpected spans %v", spans)
+	}
+	if spans[0].Status().Code != codes.Unset || spans[1].Status().Code != codes.Error {
+		t.Errorf("unexpected span statuses %v, %v", spans[0].Status(), spans[1].Status())
+	}
+	for _, attr := range spans[1].Attributes() {
+		if attr.Key == "http.status_code" && attr.Value.AsInt64() != http.StatusBadRequest {
+			t.Errorf("unexpected status code attribute %v", attr.Value.AsInt64())
+		}
+	}
+}
+
+func TestBaseURLPrefix(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte(`{"Key":"test1/test.txt"}`))
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		client *storage_go.Client
+		want   string
+	}{
+		{storage_go.NewClient(server.URL, token, map[string]string{}), "/object/test1/test.txt"},
+		{storage_go.NewClient(server.URL+"/", token, map[string]string{}), "/object/test1/test.txt"},
+		{storage_go.NewClient(server.URL+"/storage/v1/", token, map[string]string{}), "/storage/v1/object/test1/test.txt"},
+		{storage_go.NewClient("https://unused.example.com", token, map[string]string{},
+			storage_go.WithBaseURL(server.URL+"//"), storage_go.WithPathPrefix("/storage/v1/")), "/storage/v1/object/test1/test.txt"},
+	}
+	for _, test := range cases {
+		paths = nil
+		if _, err := test.client.UploadFile("test1", "test.txt", strings.NewReader("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if len(paths) != 1 || paths[0] != test.want {
+			t.Errorf("expected %s, got %v", test.want, paths)
+		}
+	}
+
+	public := storage_go.NewClient(server.URL+"/storage/v1/", token, map[string]string{}).GetPublicUrl("test1", "test.txt")
+	if public.SignedURL != server.URL+"/storage/v1/object/public/test1/test.txt" {
+		t.Errorf("unexpected public URL %s", public.SignedURL)
+	}
+}
+
+func TestWithPublicURLBase(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/storage/v1/object/upload/sign/") {
+			w.Write([]byte(`{"url":"/object/upload/sign/test1/a.png?token=b"}`))
+			return
+		}
+		w.Write([]byte(`{"signedURL":"/object/sign/test1/a.png?token=a"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL+"/storage/v1", token, map[string]string{},
+		storage_go.WithPublicURLBase("https://cdn.example.com/storage/v1/"))
+	signed, err := c.CreateSignedUrl("test1", "a.png", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transformed, err := c.CreateSignedUrlWithTransform("test1", "a.png", 60, storage_go.TransformOptions{Width: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := c.CreateSignedUploadUrl("test1", "a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, urls := range [][2]string{
+		{signed.SignedURL, "https://cdn.example.com/storage/v1/object/sign/test1/a.png?token=a"},
+		{transformed.SignedURL, "https://cdn.example.com/storage/v1/render/image/sign/test1/a.png?token=a"},
+		{upload.Url, "https://cdn.example.com/storage/v1/object/upload/sign/test1/a.png?token=b"},
+		{c.GetPublicUrl("test1", "a.png").SignedURL, "https://cdn.example.com/storage/v1/object/public/test1/a.png"},
+	} {
+		if urls[0] != urls[1] {
+			t.Errorf("expected %s, got %s", urls[1], urls[0])
+		}
+	}
+
+	// An invalid public base isn't hidden by a valid WithBaseURL after it
+	if _, err := storage_go.NewClientWithError(server.URL, token, map[string]string{},
+		storage_go.WithPublicURLBase("cdn.example.com"), storage_go.WithBaseURL(server.URL)); err == nil {
+		t.Error("expected the invalid WithPublicURLBase to be reported")
+	}
+}
+
+func TestHealth(t *testing.T) {
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/version":
+			w.Write([]byte("v1.11.2\n"))
+		case r.URL.Path == "/status" && healthy:
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	version, err := c.GetVersion()
+	if err != nil || version != "v1.11.2" {
+		t.Errorf("unexpected version %q, %v", version, err)
+	}
+	if err := c.Health(); err != nil {
+		t.Errorf("expected a healthy server, got %v", err)
+	}
+
+	healthy = false
+	var storageErr *storage_go.StorageError
+	if err := c.Health(); !errors.As(err, &storageErr) || storageErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected the unavailable status, got %v", err)
+	}
+}
+
+func TestWithTransportConfig(t *testing.T) {
+	var mu sync.Mutex
+	connections := 0
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte(`{"Key":"test1/a.txt"}`))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			connections++
+			mu.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	upload := func(c *storage_go.Client) {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := c.UploadFile("test1", "a.txt", strings.NewReader("hello")); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	cases := []struct {
+		name   string
+		client *storage_go.Client
+	}{
+		{"configured", storage_go.NewClient(server.URL, token, map[string]string{}, storage_go.WithTransportConfig(0, 8, time.Minute))},
+		{"default", storage_go.NewClient(server.URL, token, map[string]string{})},
+	}
+	for _, tc := range cases {
+		mu.Lock()
+		connections = 0
+		mu.Unlock()
+		upload(tc.client)
+		upload(tc.client)
+
+		mu.Lock()
+		// The second round reuses the idle connections of the first, http.DefaultTransport keeps only 2
+		if connections > 8 {
+			t.Errorf("%s: expected at most 8 connections, got %d", tc.name, connections)
+		}
+		mu.Unlock()
+	}
+}
+
+type stubTransport struct {
+	calls int
+}
+
+func (t *stubTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	t.calls++
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`[]`)), Request: request}, nil
+}
+
+func TestDefaultTransportSwap(t *testing.T) {
+	c := storage_go.NewClient("http://storage.test", token, map[string]string{})
+
+	// Swapped after the client is created, as mocking libraries do
+	original := http.DefaultTransport
+	stub := &stubTransport{}
+	http.DefaultTransport = stub
+	defer func() { http.DefaultTransport = original }()
+
+	if _, err := c.ListBuckets(); err != nil {
+		t.Fatal(err)
+	}
+	if stub.calls != 1 {
+		t.Errorf("expected the request to go through the current http.DefaultTransport, got %d calls", stub.calls)
+	}
+}
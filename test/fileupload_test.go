@@ -1,10 +1,23 @@
 package test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/supabase-community/storage-go"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 var rawUrl = "https://abc.supabase.co/storage/v1"
@@ -16,36 +29,479 @@ func TestUpload(t *testing.T) {
 		panic(err)
 	}
 	c := storage_go.NewClient(rawUrl, token, map[string]string{})
-	resp := c.UploadFile("test1", "test.txt", file)
-	fmt.Println(resp)
+	resp, err := c.UploadFile("test1", "test.txt", file)
+	fmt.Println(resp, err)
 
 	//resp = c.UploadFile("test1", "hola.txt", []byte("hello world"))
 	//fmt.Println(resp)
 }
 
+func TestUploadFromPath(t *testing.T) {
+	c := storage_go.NewClient(rawUrl, token, map[string]string{})
+	resp, err := c.UploadFileFromPath("test1", "from-path.txt", "dummy.txt")
+
+	fmt.Println(resp, err)
+}
+
 func TestUpdate(t *testing.T) {
 	file, err := os.Open("dummy.txt")
 	if err != nil {
 		panic(err)
 	}
 	c := storage_go.NewClient(rawUrl, token, map[string]string{})
-	resp := c.UpdateFile("test1", "test.txt", file)
+	resp, err := c.UpdateFile("test1", "test.txt", file)
 
-	fmt.Println(resp)
+	fmt.Println(resp, err)
+}
+
+func TestDownloadFile(t *testing.T) {
+	c := storage_go.NewClient(rawUrl, token, map[string]string{})
+	resp, err := c.DownloadFile("test1", "test.txt")
+
+	fmt.Println(string(resp), err)
+}
+
+func TestGetFileMetadata(t *testing.T) {
+	c := storage_go.NewClient(rawUrl, token, map[string]string{})
+	resp, err := c.GetFileMetadata("test1", "test.txt")
+
+	fmt.Println(resp, err)
+}
+
+func TestUpdateFileMetadata(t *testing.T) {
+	var copyBody map[string]interface{}
+	var upsert, userMetadata string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/object/copy":
+			upsert = r.Header.Get("X-Upsert")
+			userMetadata = r.Header.Get("X-Metadata")
+			json.NewDecoder(r.Body).Decode(&copyBody)
+			w.Write([]byte(`{"Key":"test1/a.png"}`))
+		case "/object/info/authenticated/test1/a.png":
+			w.Write([]byte(`{"name":"a.png","metadata":{"cacheControl":"max-age=60"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	object, err := c.UpdateFileMetadata("test1", "a.png", map[string]string{"owner": "me"}, "max-age=60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.Metadata.CacheControl != "max-age=60" {
+		t.Errorf("expected the updated metadata, got %+v", object.Metadata)
+	}
+	if copyBody["sourceKey"] != "a.png" || copyBody["destinationKey"] != "a.png" || upsert != "true" {
+		t.Errorf("expected the object to be copied onto itself, got %v (upsert %q)", copyBody, upsert)
+	}
+	if userMetadata != base64.StdEncoding.EncodeToString([]byte(`{"owner":"me"}`)) {
+		t.Errorf("unexpected x-metadata %q", userMetadata)
+	}
+}
+
+func TestFileExists(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/object/authenticated/test1/test.txt":
+		case "/object/authenticated/test1/missing.txt":
+			w.WriteHeader(http.StatusNotFound)
+		case "/object/authenticated/test1/bad key.txt":
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	if exists, err := c.FileExists("test1", "test.txt"); !exists || err != nil {
+		t.Errorf("expected test.txt to exist, got %v %v", exists, err)
+	}
+	if exists, err := c.FileExists("test1", "missing.txt"); exists || err != nil {
+		t.Errorf("expected missing.txt not to exist, got %v %v", exists, err)
+	}
+	if _, err := c.FileExists("test1", "private.txt"); err == nil {
+		t.Errorf("expected the 403 to be returned")
+	}
+	var storageErr *storage_go.StorageError
+	if exists, err := c.FileExists("test1", "bad key.txt"); exists || !errors.As(err, &storageErr) || storageErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected the 400 to be returned rather than read as missing, got %v %v", exists, err)
+	}
+}
+
+func TestDownloadFileStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("ETag", `"abc"`)
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	result, err := c.DownloadFileStream("test1", "a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer result.Close()
+
+	data, err := io.ReadAll(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" || result.ContentType != "image/png" || result.ContentLength != 5 || result.ETag != `"abc"` {
+		t.Errorf("unexpected download %q: %+v", data, result)
+	}
+}
+
+func TestDownloadFileIfChanged(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"abc"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"abc"`)
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	body, notModified, err := c.DownloadFileIfChanged("test1", "a.txt", `"old"`)
+	if err != nil || notModified || string(body) != "hello" {
+		t.Errorf("expected the changed object, got %q, %v, %v", body, notModified, err)
+	}
+
+	body, notModified, err = c.DownloadFileIfChanged("test1", "a.txt", `"abc"`)
+	if err != nil || !notModified || body != nil {
+		t.Errorf("expected the object to be unchanged, got %q, %v, %v", body, notModified, err)
+	}
+}
+
+func TestDownloadFileRange(t *testing.T) {
+	content := strings.NewReader("hello world")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/object/authenticated/test1/ignored.txt" {
+			w.Write([]byte("hello world"))
+			return
+		}
+		http.ServeContent(w, r, "a.txt", time.Time{}, content)
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	data, err := c.DownloadFileRange("test1", "a.txt", 6, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "world" {
+		t.Errorf("unexpected range %q", data)
+	}
+
+	if _, err := c.DownloadFileRange("test1", "ignored.txt", 6, 10); err == nil {
+		t.Error("expected an ignored range to fail")
+	}
+	if _, err := c.DownloadFileRange("test1", "a.txt", 10, 6); err == nil {
+		t.Error("expected an inverted range to be rejected")
+	}
+}
+
+func TestDownloadFileToPath(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/object/authenticated/test1/missing.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"statusCode":"404","error":"Not found","message":"Object not found"}`))
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	dir := t.TempDir()
+
+	localPath := filepath.Join(dir, "nested", "test.txt")
+	if err := c.DownloadFileToPath("test1", "test.txt", localPath); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(localPath); string(data) != "hello" {
+		t.Errorf("unexpected content %q", data)
+	}
+
+	missingPath := filepath.Join(dir, "missing.txt")
+	if err := c.DownloadFileToPath("test1", "missing.txt", missingPath); err == nil {
+		t.Errorf("expected the 404 to be returned")
+	}
+	if _, err := os.Stat(missingPath); !os.IsNotExist(err) {
+		t.Errorf("expected no file to be left behind, got %v", err)
+	}
+}
+
+func TestDownloadFileToPathMode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	dir := t.TempDir()
+
+	// A new file gets the permissions os.Create gives it
+	created, err := os.Create(filepath.Join(dir, "created.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.Close()
+	want, _ := os.Stat(created.Name())
+
+	localPath := filepath.Join(dir, "test.txt")
+	if err := c.DownloadFileToPath("test1", "test.txt", localPath); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(localPath); info.Mode().Perm() != want.Mode().Perm() {
+		t.Errorf("expected mode %v, got %v", want.Mode().Perm(), info.Mode().Perm())
+	}
+
+	// A replaced file keeps its permissions
+	if runtime.GOOS != "windows" {
+		if err := os.Chmod(localPath, 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.DownloadFileToPath("test1", "test.txt", localPath); err != nil {
+			t.Fatal(err)
+		}
+		if info, _ := os.Stat(localPath); info.Mode().Perm() != 0640 {
+			t.Errorf("expected mode 0640 to be kept, got %v", info.Mode().Perm())
+		}
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("expected no temporary files to be left behind, got %d entries", len(entries))
+	}
+}
+
+func TestDownloadFileWithTransform(t *testing.T) {
+	c := storage_go.NewClient(rawUrl, token, map[string]string{})
+	resp, err := c.DownloadFileWithTransform("test1", "avatar.png", storage_go.TransformOptions{Width: 64, Height: 64})
+
+	fmt.Println(len(resp), err)
 }
 
 func TestMoveFile(t *testing.T) {
 	c := storage_go.NewClient(rawUrl, token, map[string]string{})
-	resp := c.MoveFile("test1", "test.txt", "random/test.txt")
+	resp, err := c.MoveFile("test1", "test.txt", "random/test.txt")
 
-	fmt.Println(resp)
+	fmt.Println(resp, err)
+}
+
+func TestCopyFile(t *testing.T) {
+	c := storage_go.NewClient(rawUrl, token, map[string]string{})
+	resp, err := c.CopyFile("test1", "random/test.txt", "random/test-copy.txt")
+
+	fmt.Println(resp, err)
+}
+
+func TestCopyFileToBucket(t *testing.T) {
+	var body map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"Key":"production/avatars/a.png"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	resp, err := c.CopyFileToBucket("staging", "a.png", "production", "avatars/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body["bucketId"] != "staging" || body["destinationBucket"] != "production" || body["destinationKey"] != "avatars/a.png" {
+		t.Errorf("unexpected copy body %v", body)
+	}
+	if resp.Key != "production/avatars/a.png" {
+		t.Errorf("unexpected key %q", resp.Key)
+	}
+}
+
+func TestMoveFileToBucket(t *testing.T) {
+	bodies := map[string]map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies[body["sourceKey"]] = body
+		w.Write([]byte(`{"message":"Successfully moved"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	if _, err := c.MoveFileToBucket("staging", "a.png", "production", "avatars/a.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.MoveFile("staging", "b.png", "avatars/b.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	if body := bodies["a.png"]; body["bucketId"] != "staging" || body["destinationBucket"] != "production" || body["destinationKey"] != "avatars/a.png" {
+		t.Errorf("unexpected move body %v", body)
+	}
+	if _, ok := bodies["b.png"]["destinationBucket"]; ok {
+		t.Errorf("expected a same bucket move, got %v", bodies["b.png"])
+	}
 }
 
 func TestSignedUrl(t *testing.T) {
 	c := storage_go.NewClient(rawUrl, token, map[string]string{})
-	resp := c.CreateSignedUrl("test1", "file_example_MP4_480_1_5MG.mp4", 120)
+	resp, err := c.CreateSignedUrl("test1", "file_example_MP4_480_1_5MG.mp4", 120)
 
-	fmt.Println(resp)
+	fmt.Println(resp, err)
+}
+
+func TestSignedUrlWithTransform(t *testing.T) {
+	var transform map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		transform = body["transform"]
+		w.Write([]byte(`{"signedURL":"/object/sign/test1/avatar.png?token=abc"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	resp, err := c.CreateSignedUrlWithTransform("test1", "avatar.png", 120, storage_go.TransformOptions{Width: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if transform["width"] != float64(200) {
+		t.Errorf("transform was not sent, got %v", transform)
+	}
+	if expected := server.URL + "/render/image/sign/test1/avatar.png?token=abc"; resp.SignedURL != expected {
+		t.Errorf("expected %q, got %q", expected, resp.SignedURL)
+	}
+
+	if _, err := c.CreateSignedUrlWithTransform("test1", "avatar.png", 120, storage_go.TransformOptions{Height: -1}); err == nil {
+		t.Errorf("expected a negative height to be rejected")
+	}
+}
+
+func TestSignedUrlVariants(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var body map[string]map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		fmt.Fprintf(w, `{"signedURL":"/render/image/sign/test1/avatar.png?token=%v"}`, body["transform"]["width"])
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	variants, err := c.CreateSignedUrlVariants("test1", "avatar.png", 120, []storage_go.TransformOptions{{Width: 320}, {Width: 640}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(variants) != 2 || variants[0].Transform.Width != 320 || variants[1].Transform.Width != 640 {
+		t.Fatalf("unexpected variants %+v", variants)
+	}
+	if !strings.HasSuffix(variants[1].SignedURL, "token=640") {
+		t.Errorf("unexpected variant URL %s", variants[1].SignedURL)
+	}
+
+	requests = 0
+	if _, err := c.CreateSignedUrlVariants("test1", "avatar.png", 120, []storage_go.TransformOptions{{Width: 320}, {Width: -1}}); err == nil {
+		t.Error("expected an invalid variant to be rejected")
+	}
+	if requests != 0 {
+		t.Errorf("expected no URL to be signed, got %d requests", requests)
+	}
+}
+
+func TestSignedUrls(t *testing.T) {
+	c := storage_go.NewClient(rawUrl, token, map[string]string{})
+	resp, err := c.CreateSignedUrls("test1", []string{"test.txt", "random/test.txt"}, 120)
+
+	fmt.Println(resp, err)
+}
+
+func TestSignedUrlsPath(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/object/sign/test1" {
+			w.Write([]byte(`[{"signedURL":"/object/sign/test1/a.png?token=a"},{"error":"Object not found","signedURL":null}]`))
+			return
+		}
+		w.Write([]byte(`{"signedURL":"/object/sign/test1/a.png?token=a"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	single, err := c.CreateSignedUrl("test1", "a.png", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single.Path != "a.png" {
+		t.Errorf("expected the requested path, got %q", single.Path)
+	}
+
+	batch, err := c.CreateSignedUrls("test1", []string{"a.png", "missing.png"}, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch[0].Path != "a.png" || batch[1].Path != "missing.png" || batch[1].Error == "" {
+		t.Errorf("unexpected batch %+v", batch)
+	}
+}
+
+func TestSignedUrlExpiresIn(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	if _, err := c.CreateSignedUrl("test1", "a.png", 0); err == nil {
+		t.Error("expected a zero expiry to be rejected")
+	}
+	if _, err := c.CreateSignedUrls("test1", []string{"a.png"}, -1); err == nil {
+		t.Error("expected a negative expiry to be rejected")
+	}
+	if requests != 0 {
+		t.Errorf("expected no requests to be sent, got %d", requests)
+	}
+}
+
+func TestSignedUploadUrl(t *testing.T) {
+	c := storage_go.NewClient(rawUrl, token, map[string]string{})
+	resp, err := c.CreateSignedUploadUrl("test1", "signed/test.txt")
+
+	fmt.Println(resp, err)
+}
+
+func TestUploadToSignedUrl(t *testing.T) {
+	var query, contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("token")
+		contentType = r.Header.Get("Content-Type")
+		w.Write([]byte(`{"Key":"test1/signed/test.txt"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	resp, err := c.UploadToSignedUrl("test1", "signed/test.txt", "a+b/c=", strings.NewReader("hello"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Key != "test1/signed/test.txt" {
+		t.Errorf("unexpected key %q", resp.Key)
+	}
+	if query != "a+b/c=" {
+		t.Errorf("token was not escaped, server saw %q", query)
+	}
+	if contentType != "text/plain; charset=utf-8" {
+		t.Errorf("expected the content type of the extension, got %q", contentType)
+	}
 }
 
 func TestPublicUrl(t *testing.T) {
@@ -55,16 +511,121 @@ func TestPublicUrl(t *testing.T) {
 	fmt.Println(resp)
 }
 
+func TestPublicUrlDownload(t *testing.T) {
+	c := storage_go.NewClient(rawUrl, token, map[string]string{})
+
+	resp := c.GetPublicUrl("shield", "book.pdf", storage_go.UrlOptions{Download: true, DownloadName: "my book.pdf"})
+	if expected := rawUrl + "/object/public/shield/book.pdf?download=my+book.pdf"; resp.SignedURL != expected {
+		t.Errorf("expected %q, got %q", expected, resp.SignedURL)
+	}
+
+	resp = c.GetPublicUrl("shield", "book.pdf", storage_go.UrlOptions{Download: true})
+	if expected := rawUrl + "/object/public/shield/book.pdf?download="; resp.SignedURL != expected {
+		t.Errorf("expected %q, got %q", expected, resp.SignedURL)
+	}
+}
+
+func TestDownloadPublicFile(t *testing.T) {
+	var header http.Header
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		path = r.URL.Path
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, "secret", map[string]string{"apikey": "secret"})
+	data, err := c.DownloadPublicFile("test1", "dir/test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "hello" || path != "/object/public/test1/dir/test.txt" {
+		t.Errorf("unexpected download of %s: %q", path, data)
+	}
+	if _, ok := header["Authorization"]; ok {
+		t.Errorf("expected no authorization header, got %q", header.Get("Authorization"))
+	}
+	if _, ok := header["Apikey"]; ok {
+		t.Errorf("expected no apikey header, got %q", header.Get("Apikey"))
+	}
+}
+
+func TestGetPublicUrlChecked(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		public := r.URL.Path == "/bucket/avatars"
+		json.NewEncoder(w).Encode(storage_go.Bucket{Id: strings.TrimPrefix(r.URL.Path, "/bucket/"), Public: public})
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	public, err := c.GetPublicUrlChecked("avatars", "a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public.SignedURL != server.URL+"/object/public/avatars/a.png" {
+		t.Errorf("unexpected public URL %s", public.SignedURL)
+	}
+
+	if _, err := c.GetPublicUrlChecked("private", "a.png"); err == nil {
+		t.Error("expected a private bucket to be rejected")
+	}
+	if _, err := c.GetPublicUrlChecked("avatars", "../private/a.png"); err == nil {
+		t.Error("expected a path escaping the bucket to be rejected")
+	}
+}
+
+func TestPublicUrlWithTransform(t *testing.T) {
+	c := storage_go.NewClient(rawUrl, token, map[string]string{})
+	resp := c.GetPublicUrlWithTransform("shield", "avatar.png", storage_go.TransformOptions{
+		Width:  200,
+		Height: 100,
+		Resize: storage_go.ResizeCover,
+	})
+
+	expected := rawUrl + "/render/image/public/shield/avatar.png?height=100&resize=cover&width=200"
+	if resp.SignedURL != expected {
+		t.Errorf("expected %q, got %q", expected, resp.SignedURL)
+	}
+}
+
+func TestUrlPathEscapingBucket(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	for _, resp := range []storage_go.SignedUrlResponse{
+		c.GetPublicUrl("shield", "../private/book.pdf"),
+		c.GetPublicUrlWithTransform("shield", "a/../../private/avatar.png", storage_go.TransformOptions{Width: 64}),
+		c.GetPublicUrl("shield", "//"),
+	} {
+		if resp.SignedURL != "" || resp.Error == "" {
+			t.Errorf("expected %q to be rejected, got %+v", resp.Path, resp)
+		}
+	}
+
+	if _, err := c.CreateSignedUrls("shield", []string{"a.png", "../private/b.png"}, 60); err == nil {
+		t.Error("expected the batch to be rejected")
+	}
+	if requests != 0 {
+		t.Errorf("expected no requests to be sent, got %d", requests)
+	}
+}
+
 func TestDeleteFile(t *testing.T) {
 	c := storage_go.NewClient(rawUrl, token, map[string]string{})
-	resp := c.RemoveFile("shield", []string{"book.pdf"})
+	resp, err := c.RemoveFile("shield", []string{"book.pdf"})
 
-	fmt.Println(resp)
+	fmt.Println(resp, err)
 }
 
 func TestListFile(t *testing.T) {
 	c := storage_go.NewClient(rawUrl, token, map[string]string{})
-	resp := c.ListFiles("test1", "", storage_go.FileSearchOptions{
+	resp, err := c.ListFiles("test1", "", storage_go.FileSearchOptions{
 		Limit:  10,
 		Offset: 0,
 		SortByOptions: storage_go.SortBy{
@@ -73,5 +634,1509 @@ func TestListFile(t *testing.T) {
 		},
 	})
 
-	fmt.Println(resp)
+	fmt.Println(resp, err)
+}
+
+func TestConcurrentUploadHeaders(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		headers = map[string]http.Header{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers[r.Method+" "+r.URL.Path] = r.Header.Clone()
+		mu.Unlock()
+		if strings.HasPrefix(r.URL.Path, "/object/list/") {
+			w.Write([]byte(`[]`))
+			return
+		}
+		w.Write([]byte(`{"Key":"` + strings.TrimPrefix(r.URL.Path, "/object/") + `"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := c.UploadFile("test1", fmt.Sprintf("file-%d.txt", i), strings.NewReader("hello"))
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if _, err := c.ListFiles("test1", "", storage_go.FileSearchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		header := headers[fmt.Sprintf("POST /object/test1/file-%d.txt", i)]
+		if got := header.Values("Content-Type"); len(got) != 1 || got[0] != "text/plain; charset=utf-8" {
+			t.Errorf("upload %d: unexpected content-type %v", i, got)
+		}
+		if got := header.Values("X-Upsert"); len(got) != 1 {
+			t.Errorf("upload %d: expected a single x-upsert header, got %v", i, got)
+		}
+	}
+
+	list := headers["POST /object/list/test1"]
+	if got := list.Values("Content-Type"); len(got) != 1 || got[0] != "application/json" {
+		t.Errorf("list: upload content-type leaked into the client headers: %v", got)
+	}
+	if list.Get("X-Upsert") != "" || list.Get("Cache-Control") != "" {
+		t.Errorf("list: upload headers leaked into the client headers: %v", list)
+	}
+}
+
+func TestUpsertHeader(t *testing.T) {
+	upserts := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upserts[r.Method] = r.Header.Get("X-Upsert")
+		w.Write([]byte(`{"Key":"test1/test.txt"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	for _, update := range []bool{false, true} {
+		if _, err := c.UploadOrUpdateFile("test1", "test.txt", strings.NewReader("hello"), update, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, method := range []string{http.MethodPost, http.MethodPut} {
+		if upserts[method] != "true" {
+			t.Errorf("%s: expected x-upsert true, got %q", method, upserts[method])
+		}
+	}
+}
+
+func TestUploadPaths(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		w.Write([]byte(`{"Key":"test1/test.txt"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	expected := map[string]string{
+		"/leading.txt":     "/object/test1/leading.txt",
+		"dir//my file.txt": "/object/test1/dir/my%20file.txt",
+		"ünïcode.txt":      "/object/test1/%C3%BCn%C3%AFcode.txt",
+		"a+b.txt":          "/object/test1/a+b.txt",
+	}
+	for key, want := range expected {
+		paths = nil
+		if _, err := c.UploadFile("test1", key, strings.NewReader("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if len(paths) != 1 || paths[0] != want {
+			t.Errorf("%q: expected %s, got %v", key, want, paths)
+		}
+	}
+
+	paths = nil
+	for _, key := range []string{"../other/test.txt", "dir/../../test.txt", "/"} {
+		if _, err := c.UploadFile("test1", key, strings.NewReader("hello")); err == nil {
+			t.Errorf("%q: expected the path to be rejected", key)
+		}
+	}
+	if len(paths) != 0 {
+		t.Errorf("expected rejected paths not to be sent, got %v", paths)
+	}
+}
+
+func TestSpecialCharacterPaths(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/object/sign/"):
+			w.Write([]byte(`{"signedURL":"/object/sign/test1/a%20b%26c%23d.txt?token=a"}`))
+		case strings.HasPrefix(r.URL.Path, "/object/list/"):
+			w.Write([]byte(`[]`))
+		default:
+			w.Write([]byte(`{"Key":"test1/a b&c#d.txt"}`))
+		}
+	}))
+	defer server.Close()
+
+	name := "a b&c#d.txt"
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	if _, err := c.UploadFile("test1", name, strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DownloadFile("test1", name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateSignedUrl("test1", name, 60); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.MoveFile("test1", name, "moved/"+name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ListFiles("my bucket", "", storage_go.FileSearchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"POST /object/test1/a b&c#d.txt",
+		"GET /object/authenticated/test1/a b&c#d.txt",
+		"POST /object/sign/test1/a b&c#d.txt",
+		"POST /object/move",
+		"POST /object/list/my bucket",
+	}
+	if strings.Join(paths, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %q, got %q", expected, paths)
+	}
+
+	public := c.GetPublicUrl("test1", name)
+	if public.SignedURL != server.URL+"/object/public/test1/a%20b&c%23d.txt" {
+		t.Errorf("unexpected public URL %s", public.SignedURL)
+	}
+}
+
+func TestUpdateFileUpsert(t *testing.T) {
+	var method, upsert string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		upsert = r.Header.Get("X-Upsert")
+		w.Write([]byte(`{"Key":"test1/test.txt"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	if _, err := c.UpdateFileUpsert("test1", "test.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodPost || upsert != "true" {
+		t.Errorf("expected an upserting upload, got %s with x-upsert %q", method, upsert)
+	}
+}
+
+func TestUpdateFileWithOptions(t *testing.T) {
+	var method, contentType, cacheControl, metadata string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		contentType = r.Header.Get("Content-Type")
+		cacheControl = r.Header.Get("Cache-Control")
+		metadata = r.Header.Get("X-Metadata")
+		w.Write([]byte(`{"Key":"test1/test.txt"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	_, err := c.UpdateFileWithOptions("test1", "test.txt", strings.NewReader("hello"), storage_go.FileOptions{
+		ContentType:  "text/markdown",
+		CacheControl: "no-cache",
+		Metadata:     map[string]string{"owner": "me"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if method != http.MethodPut || contentType != "text/markdown" || cacheControl != "no-cache" {
+		t.Errorf("unexpected update %s with %q and %q", method, contentType, cacheControl)
+	}
+	if metadata != base64.StdEncoding.EncodeToString([]byte(`{"owner":"me"}`)) {
+		t.Errorf("unexpected x-metadata %q", metadata)
+	}
+}
+
+func TestUploadContentType(t *testing.T) {
+	contentTypes := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentTypes[r.URL.Path] = r.Header.Get("Content-Type")
+		w.Write([]byte(`{"Key":"` + strings.TrimPrefix(r.URL.Path, "/object/") + `"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	expected := map[string]string{
+		"image.png":  "image/png",
+		"book.pdf":   "application/pdf",
+		"hash-12345": "text/plain;charset=UTF-8",
+	}
+	for name := range expected {
+		if _, err := c.UploadFile("test1", name, strings.NewReader("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, contentType := range expected {
+		if got := contentTypes["/object/test1/"+name]; got != contentType {
+			t.Errorf("%s: expected %q, got %q", name, contentType, got)
+		}
+	}
+}
+
+func TestUploadDetectContentType(t *testing.T) {
+	var (
+		contentType string
+		received    []byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		received, _ = io.ReadAll(r.Body)
+		w.Write([]byte(`{"Key":"test1/5d41402abc4b2a76"}`))
+	}))
+	defer server.Close()
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 1024)...)
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	_, err := c.UploadFileWithOptions("test1", "5d41402abc4b2a76", bytes.NewReader(png), storage_go.FileOptions{
+		DetectContentType: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType != "image/png" {
+		t.Errorf("expected a sniffed image/png, got %q", contentType)
+	}
+	if !bytes.Equal(received, png) {
+		t.Errorf("sniffed bytes were lost, received %d of %d bytes", len(received), len(png))
+	}
+}
+
+func TestUploadProgress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{"Key":"test1/video.mp4"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	data := bytes.Repeat([]byte("a"), 64*1024)
+
+	var lastSent, lastTotal int64
+	_, err := c.UploadFileWithOptions("test1", "video.mp4", bytes.NewReader(data), storage_go.FileOptions{
+		OnProgress: func(bytesSent int64, totalBytes int64) {
+			lastSent, lastTotal = bytesSent, totalBytes
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastSent != int64(len(data)) || lastTotal != int64(len(data)) {
+		t.Errorf("expected %d/%d bytes, got %d/%d", len(data), len(data), lastSent, lastTotal)
+	}
+
+	// A plain reader has no known size
+	_, err = c.UploadFileWithOptions("test1", "video.mp4", io.LimitReader(bytes.NewReader(data), 1024), storage_go.FileOptions{
+		OnProgress: func(bytesSent int64, totalBytes int64) {
+			lastSent, lastTotal = bytesSent, totalBytes
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastSent != 1024 || lastTotal != -1 {
+		t.Errorf("expected 1024/-1 bytes, got %d/%d", lastSent, lastTotal)
+	}
+}
+
+func TestListFilesAll(t *testing.T) {
+	var offsets []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body storage_go.ListFileRequestBody
+		json.NewDecoder(r.Body).Decode(&body)
+		offsets = append(offsets, body.Offset)
+
+		// 5 objects in total
+		var page []storage_go.FileObject
+		for i := body.Offset; i < body.Offset+body.Limit && i < 5; i++ {
+			page = append(page, storage_go.FileObject{Name: fmt.Sprintf("file-%d.txt", i)})
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	files, err := c.ListFilesAll("test1", "", storage_go.FileSearchOptions{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 5 {
+		t.Errorf("expected 5 files, got %d", len(files))
+	}
+	if fmt.Sprint(offsets) != "[0 2 4]" {
+		t.Errorf("unexpected page offsets %v", offsets)
+	}
+}
+
+func TestListFilesFunc(t *testing.T) {
+	pages := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body storage_go.ListFileRequestBody
+		json.NewDecoder(r.Body).Decode(&body)
+		pages++
+
+		var page []storage_go.FileObject
+		for i := body.Offset; i < body.Offset+body.Limit && i < 5; i++ {
+			page = append(page, storage_go.FileObject{Name: fmt.Sprintf("file-%d.txt", i)})
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	var names []string
+	err := c.ListFilesFunc("test1", "", storage_go.FileSearchOptions{Limit: 2}, func(file storage_go.FileObject) error {
+		names = append(names, file.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 5 || pages != 3 {
+		t.Errorf("expected 5 files over 3 pages, got %v over %d", names, pages)
+	}
+
+	// Stopping early doesn't fetch the remaining pages
+	pages = 0
+	stop := errors.New("stop")
+	err = c.ListFilesFunc("test1", "", storage_go.FileSearchOptions{Limit: 2}, func(file storage_go.FileObject) error {
+		if file.Name == "file-1.txt" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || pages != 1 {
+		t.Errorf("expected to stop after the first page, got %v after %d", err, pages)
+	}
+}
+
+func TestListFilesSearch(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	if _, err := c.ListFiles("test1", "", storage_go.FileSearchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := body["search"]; ok {
+		t.Errorf("expected no search without a fragment, got %v", body)
+	}
+
+	if _, err := c.ListFilesAll("test1", "", storage_go.FileSearchOptions{Search: "report"}); err != nil {
+		t.Fatal(err)
+	}
+	if body["search"] != "report" {
+		t.Errorf("expected the search fragment to be sent, got %v", body)
+	}
+}
+
+func TestListFilesSortValidation(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	for _, sortBy := range []storage_go.SortBy{
+		{Order: "ASCENDING"},
+		{Column: "size ", Order: storage_go.SortDesc},
+		{Column: "size"},
+		{Column: "metadata"},
+		{Column: "Name"},
+	} {
+		if _, err := c.ListFiles("test1", "", storage_go.FileSearchOptions{SortByOptions: sortBy}); err == nil {
+			t.Errorf("%+v: expected the sort options to be rejected", sortBy)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("expected invalid listings not to be sent, got %d requests", requests)
+	}
+
+	_, err := c.ListFiles("test1", "", storage_go.FileSearchOptions{SortByOptions: storage_go.SortBy{Column: "size"}})
+	if err == nil || !strings.Contains(err.Error(), "last_accessed_at") {
+		t.Errorf("expected the error to list the sort columns, got %v", err)
+	}
+
+	for _, column := range []string{storage_go.SortByName, storage_go.SortByCreatedAt, storage_go.SortByUpdatedAt, storage_go.SortByLastAccessedAt} {
+		sortBy := storage_go.SortBy{Column: column, Order: storage_go.SortDesc}
+		if _, err := c.ListFiles("test1", "", storage_go.FileSearchOptions{SortByOptions: sortBy}); err != nil {
+			t.Errorf("%s: %v", column, err)
+		}
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	expected := time.Date(2023, 1, 2, 15, 4, 5, 123456000, time.UTC)
+	for _, value := range []string{
+		"2023-01-02T15:04:05.123456Z",
+		"2023-01-02T15:04:05.123456+00:00",
+		"2023-01-02T15:04:05.123456+00",
+		"2023-01-02 15:04:05.123456+00",
+	} {
+		parsed, err := storage_go.ParseTimestamp(value)
+		if err != nil {
+			t.Errorf("%s: %v", value, err)
+			continue
+		}
+		if !parsed.Equal(expected) {
+			t.Errorf("%s: expected %s, got %s", value, expected, parsed)
+		}
+	}
+
+	file := storage_go.FileObject{UpdatedAt: "2023-01-02T15:04:05.123456+00"}
+	if !file.UpdatedTime().Equal(expected) {
+		t.Errorf("unexpected UpdatedTime %s", file.UpdatedTime())
+	}
+}
+
+func TestObjectMetadata(t *testing.T) {
+	var file storage_go.FileObject
+	err := json.Unmarshal([]byte(`{
+		"name": "avatar.png",
+		"metadata": {"eTag": "\"abc\"", "size": 1024, "mimetype": "image/png", "cacheControl": "max-age=3600", "httpStatusCode": 200}
+	}`), &file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := file.Metadata
+	if metadata.Size != 1024 || metadata.MimeType != "image/png" || metadata.ETag != `"abc"` || metadata.CacheControl != "max-age=3600" {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+	if metadata.Raw["httpStatusCode"] != float64(200) {
+		t.Errorf("unknown fields were not kept, got %v", metadata.Raw)
+	}
+}
+
+func TestUploadFiles(t *testing.T) {
+	var (
+		mu             sync.Mutex
+		inFlight, peak int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		if strings.HasSuffix(r.URL.Path, "bad.txt") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"statusCode":"400","error":"Bad request","message":"Invalid key"}`))
+			return
+		}
+		w.Write([]byte(`{"Key":"` + strings.TrimPrefix(r.URL.Path, "/object/") + `"}`))
+	}))
+	defer server.Close()
+
+	var files []storage_go.UploadItem
+	for i := 0; i < 10; i++ {
+		files = append(files, storage_go.UploadItem{Path: fmt.Sprintf("photo-%d.txt", i), Data: strings.NewReader("hello")})
+	}
+	files = append(files, storage_go.UploadItem{Path: "bad.txt", Data: strings.NewReader("hello")})
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	results, err := c.UploadFiles("test1", files, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, result := range results[:10] {
+		if result.Err != nil || result.Response.Key != "test1/"+files[i].Path {
+			t.Errorf("%s: unexpected result %+v", files[i].Path, result)
+		}
+	}
+	if results[10].Path != "bad.txt" || results[10].Err == nil {
+		t.Errorf("expected bad.txt to fail, got %+v", results[10])
+	}
+	if peak > 3 {
+		t.Errorf("expected at most 3 concurrent uploads, got %d", peak)
+	}
+}
+
+func TestMoveFiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["sourceKey"] == "missing.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"statusCode":"404","error":"Not found","message":"Object not found"}`))
+			return
+		}
+		w.Write([]byte(`{"message":"Successfully moved"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	results, err := c.MoveFiles("test1", []storage_go.MovePair{
+		{SourceKey: "a.txt", DestinationKey: "archive/a.txt"},
+		{SourceKey: "missing.txt", DestinationKey: "archive/missing.txt"},
+		{SourceKey: "b.txt", DestinationKey: "archive/b.txt"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results[0].Err != nil || results[2].Err != nil {
+		t.Errorf("expected the other moves to succeed, got %+v", results)
+	}
+	if results[1].Path != "missing.txt" || results[1].Err == nil {
+		t.Errorf("expected missing.txt to fail, got %+v", results[1])
+	}
+}
+
+func TestUploadFileResumable(t *testing.T) {
+	var (
+		received []byte
+		patches  int
+		metadata string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			metadata = r.Header.Get("Upload-Metadata")
+			w.Header().Set("Location", "http://"+r.Host+"/upload/resumable/upload-1")
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodHead:
+			w.Header().Set("Upload-Offset", fmt.Sprint(len(received)))
+		case http.MethodPatch:
+			patches++
+			// The connection drops on the second chunk
+			if patches == 2 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if r.Header.Get("Upload-Offset") != fmt.Sprint(len(received)) {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			chunk, _ := io.ReadAll(r.Body)
+			received = append(received, chunk...)
+			w.Header().Set("Upload-Offset", fmt.Sprint(len(received)))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	data := []byte("hello resumable world")
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	upload, err := c.UploadFileResumable("test1", "video.mp4", bytes.NewReader(data), int64(len(data)), storage_go.ResumableOptions{
+		ChunkSize: 8,
+	})
+	if err == nil {
+		t.Fatal("expected the second chunk to fail")
+	}
+	if upload == nil || upload.Offset != 8 {
+		t.Fatalf("expected the upload to stop at offset 8, got %+v", upload)
+	}
+	if !strings.Contains(metadata, "bucketName dGVzdDE=") {
+		t.Errorf("unexpected upload metadata %q", metadata)
+	}
+
+	// Resume in a fresh call from the stored upload URL
+	upload, err = c.UploadFileResumable("test1", "video.mp4", bytes.NewReader(data), int64(len(data)), storage_go.ResumableOptions{
+		ChunkSize: 8,
+		UploadUrl: upload.Url,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.Offset != int64(len(data)) || !bytes.Equal(received, data) {
+		t.Errorf("expected the full data after resuming, got %q", received)
+	}
+}
+
+func TestUploadFileResumableStalledOffset(t *testing.T) {
+	patches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			w.Header().Set("Location", "http://"+r.Host+"/upload/resumable/upload-1")
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodPatch:
+			patches++
+			io.Copy(io.Discard, r.Body)
+			// The offset never moves past the first chunk
+			w.Header().Set("Upload-Offset", "8")
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	data := []byte("hello resumable world")
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	upload, err := c.UploadFileResumable("test1", "video.mp4", bytes.NewReader(data), int64(len(data)), storage_go.ResumableOptions{
+		ChunkSize: 8,
+	})
+	if err == nil {
+		t.Fatal("expected a stalled offset to fail the upload")
+	}
+	if patches != 2 {
+		t.Errorf("expected the upload to stop at the second chunk, got %d chunks", patches)
+	}
+	if upload == nil || upload.Offset != 8 {
+		t.Errorf("expected the upload to keep the last confirmed offset, got %+v", upload)
+	}
+}
+
+func TestUploadMultipart(t *testing.T) {
+	var (
+		fileName, fileType, cacheControl, upsert string
+		received                                 []byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upsert = r.Header.Get("X-Upsert")
+		cacheControl = r.Header.Get("Cache-Control")
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fileName, fileType = header.Filename, header.Header.Get("Content-Type")
+		received, _ = io.ReadAll(file)
+		w.Write([]byte(`{"Key":"test1/docs/book.pdf"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	_, err := c.UploadFileWithOptions("test1", "docs/book.pdf", strings.NewReader("%PDF-1.4"), storage_go.FileOptions{
+		Upsert:       true,
+		UseMultipart: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fileName != "book.pdf" || fileType != "application/pdf" || string(received) != "%PDF-1.4" {
+		t.Errorf("unexpected file part %q %q %q", fileName, fileType, received)
+	}
+	if upsert != "true" || cacheControl == "" {
+		t.Errorf("upload headers were not preserved, got x-upsert %q cache-control %q", upsert, cacheControl)
+	}
+}
+
+func TestUploadMetadata(t *testing.T) {
+	var header, field string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(`{"name":"a.txt","user_metadata":{"uploaded-by":"me"}}`))
+		default:
+			header = r.Header.Get("X-Metadata")
+			field = r.FormValue("metadata")
+			w.Write([]byte(`{"Key":"test1/a.txt"}`))
+		}
+	}))
+	defer server.Close()
+
+	metadata := map[string]string{"uploaded-by": "me"}
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	_, err := c.UploadFileWithOptions("test1", "a.txt", strings.NewReader("hello"), storage_go.FileOptions{
+		Metadata:     metadata,
+		UseMultipart: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header != base64.StdEncoding.EncodeToString([]byte(`{"uploaded-by":"me"}`)) || field != `{"uploaded-by":"me"}` {
+		t.Errorf("unexpected metadata header %q and field %q", header, field)
+	}
+
+	object, err := c.GetFileMetadata("test1", "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.UserMetadata["uploaded-by"] != "me" {
+		t.Errorf("expected the metadata back, got %v", object.UserMetadata)
+	}
+}
+
+func TestUploadHeaders(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		w.Write([]byte(`{"Key":"test1/test.txt"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{"X-Tenant-ID": "global"})
+	_, err := c.UploadFileWithOptions("test1", "test.txt", strings.NewReader("hello"), storage_go.FileOptions{
+		Headers: map[string]string{
+			"X-Tenant-ID":                 "upload",
+			"X-Upsert-Overwrite-Metadata": "true",
+			"Cache-Control":               "no-cache",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Get("X-Tenant-ID") != "upload" || header.Get("X-Upsert-Overwrite-Metadata") != "true" {
+		t.Errorf("per upload headers were not applied: %v", header)
+	}
+	if got := header.Values("Cache-Control"); len(got) != 1 || got[0] != "no-cache" {
+		t.Errorf("expected cache-control to be overridden, got %v", got)
+	}
+}
+
+func TestUploadCacheControl(t *testing.T) {
+	var cacheControls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cacheControls = append(cacheControls, r.Header.Get("Cache-Control"))
+		w.Write([]byte(`{"Key":"test1/test.txt"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	for _, cacheControl := range []string{"", "public, max-age=31536000, immutable"} {
+		_, err := c.UploadFileWithOptions("test1", "test.txt", strings.NewReader("hello"), storage_go.FileOptions{
+			CacheControl: cacheControl,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if cacheControls[0] != "3600" || cacheControls[1] != "public, max-age=31536000, immutable" {
+		t.Errorf("unexpected cache-control headers %q", cacheControls)
+	}
+}
+
+func TestUploadResponseETag(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"5d41402abc4b2a76b9719d911017c592"`)
+		w.Write([]byte(`{"Id":"0d7a0f4e-6d0b-4b8b-9d0b-3c6c0f8b1a2e","Key":"test1/test.txt"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	resp, err := c.UploadFile("test1", "test.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Id != "0d7a0f4e-6d0b-4b8b-9d0b-3c6c0f8b1a2e" || resp.ETag != `"5d41402abc4b2a76b9719d911017c592"` {
+		t.Errorf("unexpected upload response %+v", resp)
+	}
+	if resp.Version != "" {
+		t.Errorf("expected an empty version, got %q", resp.Version)
+	}
+}
+
+func TestUploadStatusCode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		}
+		w.Write([]byte(`{"Key":"test1/test.txt"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	created, err := c.UploadFile("test1", "test.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := c.UpdateFile("test1", "test.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if created.StatusCode != http.StatusCreated || updated.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status codes %d and %d", created.StatusCode, updated.StatusCode)
+	}
+}
+
+func TestDeleteFiles(t *testing.T) {
+	var body map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete || r.URL.Path != "/object/test1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`[{"name":"dir/a.txt","bucket_id":"test1"}]`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	deleted, err := c.DeleteFiles("test1", []string{"/dir/a.txt", "dir/missing.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(body["prefixes"]) != "[dir/a.txt dir/missing.txt]" {
+		t.Errorf("unexpected keys %v", body["prefixes"])
+	}
+	if len(deleted) != 1 || deleted[0].Name != "dir/a.txt" {
+		t.Errorf("unexpected deleted objects %+v", deleted)
+	}
+
+	removed, err := c.RemoveFile("test1", []string{"dir/a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].BucketId != "test1" {
+		t.Errorf("unexpected removed objects %+v", removed)
+	}
+}
+
+func TestDeleteByPrefix(t *testing.T) {
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			var body storage_go.ListFileRequestBody
+			json.NewDecoder(r.Body).Decode(&body)
+			switch body.Prefix {
+			case "users/42":
+				w.Write([]byte(`[{"name":"avatar.png","id":"1"},{"name":"docs","id":null}]`))
+			case "users/42/docs":
+				w.Write([]byte(`[{"name":"a.pdf","id":"2"},{"name":"b.pdf","id":"3"}]`))
+			default:
+				w.Write([]byte(`[]`))
+			}
+		case http.MethodDelete:
+			var body map[string][]string
+			json.NewDecoder(r.Body).Decode(&body)
+			deleted = append(deleted, body["prefixes"]...)
+			json.NewEncoder(w).Encode(make([]storage_go.FileObject, len(body["prefixes"])))
+		}
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	for _, prefix := range []string{"", "/", "//"} {
+		if _, err := c.DeleteByPrefix("test1", prefix); err == nil {
+			t.Errorf("%q: expected an empty prefix to be rejected", prefix)
+		}
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("expected nothing to be deleted, got %v", deleted)
+	}
+
+	count, err := c.DeleteByPrefix("test1", "users/42/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || fmt.Sprint(deleted) != "[users/42/avatar.png users/42/docs/a.pdf users/42/docs/b.pdf]" {
+		t.Errorf("unexpected delete of %d objects: %v", count, deleted)
+	}
+}
+
+func TestUploadSlashRuns(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte(`{"Key":"test1/a/b"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	expected := map[string]string{
+		"a///b":    "/object/test1/a/b",
+		"a////b":   "/object/test1/a/b",
+		"//a//b":   "/object/test1/a/b",
+		"a/b///":   "/object/test1/a/b/",
+		"a//b//c/": "/object/test1/a/b/c/",
+	}
+	for key, want := range expected {
+		paths = nil
+		if _, err := c.UploadFile("test1", key, strings.NewReader("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if len(paths) != 1 || paths[0] != want {
+			t.Errorf("%q: expected %s, got %v", key, want, paths)
+		}
+	}
+}
+
+func TestNoContentResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	if deleted, err := c.DeleteFiles("test1", []string{"a.txt"}); err != nil || len(deleted) != 0 {
+		t.Errorf("delete: expected an empty success, got %v, %v", deleted, err)
+	}
+	if removed, err := c.RemoveFile("test1", []string{"a.txt"}); err != nil || len(removed) != 0 {
+		t.Errorf("remove: expected an empty success, got %v, %v", removed, err)
+	}
+	if _, err := c.MoveFile("test1", "a.txt", "b.txt"); err != nil {
+		t.Errorf("move: expected an empty success, got %v", err)
+	}
+	if _, err := c.UploadFile("test1", "a.txt", strings.NewReader("hello")); err != nil {
+		t.Errorf("upload: expected an empty success, got %v", err)
+	}
+	if files, err := c.ListFiles("test1", "", storage_go.FileSearchOptions{}); err != nil || len(files) != 0 {
+		t.Errorf("list: expected an empty success, got %v, %v", files, err)
+	}
+}
+
+func TestUploadContentLength(t *testing.T) {
+	var lengths []int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		lengths = append(lengths, r.ContentLength)
+		w.Write([]byte(`{"Key":"test1/a.txt"}`))
+	}))
+	defer server.Close()
+
+	file, err := os.Open("dummy.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	cases := []struct {
+		name string
+		data io.Reader
+		want int64
+	}{
+		{"bytes", bytes.NewReader([]byte("hello")), 5},
+		{"strings", strings.NewReader("hello world"), 11},
+		{"file", file, info.Size()},
+		{"unknown", io.MultiReader(strings.NewReader("hello")), -1},
+	}
+	for _, tc := range cases {
+		lengths = nil
+		if _, err := c.UploadFile("test1", "a.txt", tc.data); err != nil {
+			t.Fatal(err)
+		}
+		if len(lengths) != 1 || lengths[0] != tc.want {
+			t.Errorf("%s: expected a content length of %d, got %v", tc.name, tc.want, lengths)
+		}
+	}
+}
+
+func TestMaxUploadSize(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		// Aborted uploads end without the closing chunk and fail to read
+		if body, err := io.ReadAll(r.Body); err == nil {
+			received = append(received, string(body))
+		}
+		w.Write([]byte(`{"Key":"test1/a.txt"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{}, storage_go.WithMaxUploadSize(5))
+	if _, err := c.UploadFile("test1", "a.txt", strings.NewReader("hello world")); !errors.Is(err, storage_go.ErrUploadTooLarge) {
+		t.Errorf("sized: expected ErrUploadTooLarge, got %v", err)
+	}
+	mu.Lock()
+	if requests != 0 {
+		t.Errorf("sized: expected no request, got %d", requests)
+	}
+	mu.Unlock()
+
+	if _, err := c.UploadFile("test1", "a.txt", io.MultiReader(strings.NewReader("hello world"))); !errors.Is(err, storage_go.ErrUploadTooLarge) {
+		t.Errorf("unsized: expected ErrUploadTooLarge, got %v", err)
+	}
+
+	if _, err := c.UploadFile("test1", "a.txt", io.MultiReader(strings.NewReader("hello"))); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 || received[0] != "hello" {
+		t.Errorf("expected an upload at the limit to be sent whole, got %q", received)
+	}
+}
+
+func TestListFilesRequestBody(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		body = string(raw)
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	cases := []struct {
+		options storage_go.FileSearchOptions
+		want    string
+	}{
+		{storage_go.FileSearchOptions{}, `{"limit":100,"offset":0,"sortBy":{"column":"name","order":"asc"},"prefix":"folder"}`},
+		{storage_go.FileSearchOptions{Limit: 10, Offset: 20, Search: "report"}, `{"limit":10,"offset":20,"sortBy":{"column":"name","order":"asc"},"prefix":"folder","search":"report"}`},
+		{storage_go.FileSearchOptions{SortByOptions: storage_go.SortBy{Column: storage_go.SortByCreatedAt}}, `{"limit":100,"offset":0,"sortBy":{"column":"created_at","order":"asc"},"prefix":"folder"}`},
+	}
+	for _, tc := range cases {
+		if _, err := c.ListFiles("test1", "folder", tc.options); err != nil {
+			t.Fatal(err)
+		}
+		if body != tc.want {
+			t.Errorf("expected %s, got %s", tc.want, body)
+		}
+	}
+
+	encoded, _ := json.Marshal(storage_go.FileSearchOptions{})
+	if string(encoded) != `{"sortBy":{}}` {
+		t.Errorf("expected zero search options to be omitted, got %s", encoded)
+	}
+}
+
+func TestDeleteDryRun(t *testing.T) {
+	var listed []string
+	deletes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			var body storage_go.ListFileRequestBody
+			json.NewDecoder(r.Body).Decode(&body)
+			listed = append(listed, body.Prefix)
+			switch body.Prefix {
+			case "users/42":
+				w.Write([]byte(`[{"name":"avatar.png","id":"1"},{"name":"docs","id":null}]`))
+			case "users/42/docs":
+				w.Write([]byte(`[{"name":"a.pdf","id":"2"}]`))
+			case "":
+				w.Write([]byte(`[{"name":"root.txt","id":"3"},{"name":"users","id":null}]`))
+			default:
+				w.Write([]byte(`[]`))
+			}
+		case http.MethodDelete:
+			deletes++
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	keys, err := c.DeleteByPrefixWithOptions("test1", "users/42", storage_go.DeleteOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(keys) != "[users/42/avatar.png users/42/docs/a.pdf]" {
+		t.Errorf("unexpected keys to delete: %v", keys)
+	}
+
+	listed = nil
+	objects, err := c.RemoveFileWithOptions("test1", []string{"users/42/avatar.png", "/root.txt", "users/42/missing.png", "users"}, storage_go.DeleteOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].Name != "users/42/avatar.png" || objects[0].Id != "1" || objects[1].Name != "root.txt" {
+		t.Errorf("unexpected objects to remove: %v", objects)
+	}
+	if fmt.Sprint(listed) != "[users/42 ]" {
+		t.Errorf("expected each folder to be listed once, got %q", listed)
+	}
+	if deletes != 0 {
+		t.Errorf("expected a dry run to delete nothing, got %d deletes", deletes)
+	}
+}
+
+func TestUploadPreserveMetadata(t *testing.T) {
+	var header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/object/info/authenticated/test1/missing.txt":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"statusCode":"404","error":"not_found","message":"Object not found"}`))
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"name":"a.txt","user_metadata":{"owner":"ana","pages":3}}`))
+		default:
+			header = r.Header.Get("X-Metadata")
+			w.Write([]byte(`{"Key":"test1/a.txt"}`))
+		}
+	}))
+	defer server.Close()
+
+	decoded := func() map[string]string {
+		raw, _ := base64.StdEncoding.DecodeString(header)
+		var metadata map[string]string
+		json.Unmarshal(raw, &metadata)
+		return metadata
+	}
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	_, err := c.UploadFileWithOptions("test1", "a.txt", strings.NewReader("hello"), storage_go.FileOptions{
+		Upsert:           true,
+		PreserveMetadata: true,
+		Metadata:         map[string]string{"owner": "bo", "version": "2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(decoded()) != "map[owner:bo pages:3 version:2]" {
+		t.Errorf("expected the metadata merged over the existing one, got %v", decoded())
+	}
+
+	_, err = c.UpdateFileWithOptions("test1", "missing.txt", strings.NewReader("hello"), storage_go.FileOptions{
+		PreserveMetadata: true,
+		Metadata:         map[string]string{"version": "1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(decoded()) != "map[version:1]" {
+		t.Errorf("expected only the given metadata for a new object, got %v", decoded())
+	}
+
+	header = ""
+	if _, err := c.UploadFileWithOptions("test1", "a.txt", strings.NewReader("hello"), storage_go.FileOptions{Upsert: true}); err != nil {
+		t.Fatal(err)
+	}
+	if header != "" {
+		t.Errorf("expected no metadata without PreserveMetadata, got %q", header)
+	}
+}
+
+func TestUploadIdempotencyKey(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if len(keys)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"Key":"test1/a.txt"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{}, storage_go.WithRetry(2, time.Millisecond))
+	_, err := c.UploadFileWithOptions("test1", "a.txt", strings.NewReader("hello"), storage_go.FileOptions{IdempotencyKey: "upload-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(keys) != "[upload-1 upload-1]" {
+		t.Errorf("expected the keyed upload to be retried with its key, got %q", keys)
+	}
+
+	keys = nil
+	if _, err := c.UploadFile("test1", "a.txt", strings.NewReader("hello")); err == nil {
+		t.Error("expected an upload without a key not to be retried")
+	}
+	if len(keys) != 1 {
+		t.Errorf("expected a single attempt, got %d", len(keys))
+	}
+}
+
+func TestCreateSignedUrlsVarying(t *testing.T) {
+	var batches []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ExpiresIn int      `json:"expiresIn"`
+			Paths     []string `json:"paths"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		batches = append(batches, fmt.Sprint(body.ExpiresIn, body.Paths))
+		var response []map[string]string
+		for _, path := range body.Paths {
+			response = append(response, map[string]string{
+				"path":      path,
+				"signedURL": fmt.Sprintf("/object/sign/test1/%s?token=%d", path, body.ExpiresIn),
+			})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	urls, err := c.CreateSignedUrlsVarying("test1", []storage_go.SignRequest{
+		{Path: "preview-1.png", ExpiresIn: 60},
+		{Path: "share.png", ExpiresIn: 86400},
+		{Path: "preview-2.png", ExpiresIn: 60},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(batches) != "[60 [preview-1.png preview-2.png] 86400 [share.png]]" {
+		t.Errorf("expected one batch per expiry, got %v", batches)
+	}
+	expected := []string{"preview-1.png?token=60", "share.png?token=86400", "preview-2.png?token=60"}
+	for i, want := range expected {
+		if i >= len(urls) || urls[i].SignedURL != server.URL+"/object/sign/test1/"+want {
+			t.Errorf("%d: expected the URL of %s in request order, got %v", i, want, urls)
+		}
+	}
+
+	batches = nil
+	if _, err := c.CreateSignedUrlsVarying("test1", []storage_go.SignRequest{{Path: "a.png", ExpiresIn: 60}, {Path: "b.png"}}); err == nil {
+		t.Error("expected a missing expiry to be rejected")
+	}
+	if len(batches) != 0 {
+		t.Errorf("expected no request for an invalid batch, got %v", batches)
+	}
+}
+
+func TestUploadCompress(t *testing.T) {
+	var encoding string
+	var stored []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(stored)
+			return
+		}
+		encoding = r.Header.Get("Content-Encoding")
+		stored, _ = io.ReadAll(r.Body)
+		w.Write([]byte(`{"Key":"test1/a.json"}`))
+	}))
+	defer server.Close()
+
+	data := strings.Repeat(`{"hello":"world"}`, 100)
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	if _, err := c.UploadFileWithOptions("test1", "a.json", strings.NewReader(data), storage_go.FileOptions{Compress: true}); err != nil {
+		t.Fatal(err)
+	}
+	if encoding != "gzip" {
+		t.Errorf("expected a gzip content encoding, got %q", encoding)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(stored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uncompressed, _ := io.ReadAll(reader); string(uncompressed) != data || len(stored) >= len(data) {
+		t.Errorf("expected the data compressed, got %d stored bytes decompressing to %d", len(stored), len(uncompressed))
+	}
+
+	// Asking for an encoding keeps the transport from decompressing on its own
+	headers := map[string]string{"Accept-Encoding": "gzip"}
+	raw, err := storage_go.NewClient(server.URL, token, headers).DownloadFile("test1", "a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, stored) {
+		t.Errorf("expected the stored bytes without WithDecompression, got %d bytes", len(raw))
+	}
+	downloaded, err := storage_go.NewClient(server.URL, token, headers, storage_go.WithDecompression()).DownloadFile("test1", "a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(downloaded) != data {
+		t.Errorf("expected the decompressed data, got %d bytes", len(downloaded))
+	}
+}
+
+func TestUploadObjectExists(t *testing.T) {
+	cases := []struct {
+		status int
+		body   string
+		exists bool
+	}{
+		{http.StatusConflict, `{"statusCode":"409","error":"Duplicate","message":"The resource already exists"}`, true},
+		// Older servers answer 400 and only report the conflict in the body
+		{http.StatusBadRequest, `{"statusCode":"409","error":"Duplicate","message":"The resource already exists"}`, true},
+		{http.StatusForbidden, `{"statusCode":"403","error":"Unauthorized","message":"new row violates row-level security policy"}`, false},
+	}
+	for _, tc := range cases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.status)
+			w.Write([]byte(tc.body))
+		}))
+
+		c := storage_go.NewClient(server.URL, token, map[string]string{})
+		_, err := c.UploadFile("test1", "a.txt", strings.NewReader("hello"))
+		if errors.Is(err, storage_go.ErrObjectExists) != tc.exists {
+			t.Errorf("%d: expected ErrObjectExists to match %v, got %v", tc.status, tc.exists, err)
+		}
+		var storageErr *storage_go.StorageError
+		if !errors.As(err, &storageErr) || storageErr.Message == "" {
+			t.Errorf("%d: expected the StorageError to be kept, got %v", tc.status, err)
+		}
+		server.Close()
+	}
+}
+
+func TestObjectNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Older servers answer 400 and only report the missing object in the body
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"statusCode":"404","error":"not_found","message":"Object not found"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	_, downloadErr := c.DownloadFile("test1", "missing.txt")
+	_, infoErr := c.GetFileMetadata("test1", "missing.txt")
+	_, signErr := c.CreateSignedUrl("test1", "missing.txt", 60)
+	_, moveErr := c.MoveFile("test1", "missing.txt", "b.txt")
+	for name, err := range map[string]error{"download": downloadErr, "info": infoErr, "sign": signErr, "move": moveErr} {
+		if !errors.Is(err, storage_go.ErrObjectNotFound) {
+			t.Errorf("%s: expected ErrObjectNotFound, got %v", name, err)
+		}
+		if errors.Is(err, storage_go.ErrObjectExists) {
+			t.Errorf("%s: expected no ErrObjectExists, got %v", name, err)
+		}
+	}
+
+	// An upload answers 404 for a missing bucket, the object not existing yet is expected
+	if _, err := c.UploadFile("test1", "a.txt", strings.NewReader("hello")); err == nil || errors.Is(err, storage_go.ErrObjectNotFound) {
+		t.Errorf("upload: expected a plain StorageError, got %v", err)
+	}
+}
+
+func TestUploadPipeProducerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{"Key":"test1/a.mp4"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	producerErr := errors.New("transcoder crashed")
+	produce := func(err error) io.Reader {
+		reader, writer := io.Pipe()
+		go func() {
+			writer.Write([]byte("first frames"))
+			writer.CloseWithError(err)
+		}()
+		return reader
+	}
+
+	cases := []struct {
+		name    string
+		options storage_go.FileOptions
+	}{
+		{"raw", storage_go.FileOptions{}},
+		{"multipart", storage_go.FileOptions{UseMultipart: true}},
+		{"compressed", storage_go.FileOptions{Compress: true}},
+	}
+	for _, tc := range cases {
+		if _, err := c.UploadFileWithOptions("test1", "a.mp4", produce(producerErr), tc.options); !errors.Is(err, producerErr) {
+			t.Errorf("%s: expected the producer error, got %v", tc.name, err)
+		}
+		if _, err := c.UploadFileWithOptions("test1", "a.mp4", produce(nil), tc.options); err != nil {
+			t.Errorf("%s: expected a complete stream to upload, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestCreateSignedUrlsForPrefix(t *testing.T) {
+	var signed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/object/list/") {
+			var body storage_go.ListFileRequestBody
+			json.NewDecoder(r.Body).Decode(&body)
+			switch body.Prefix {
+			case "gallery":
+				w.Write([]byte(`[{"name":"a.png","id":"1"},{"name":"2024","id":null}]`))
+			case "gallery/2024":
+				w.Write([]byte(`[{"name":"b.png","id":"2"}]`))
+			default:
+				w.Write([]byte(`[]`))
+			}
+			return
+		}
+
+		var body struct {
+			ExpiresIn int      `json:"expiresIn"`
+			Paths     []string `json:"paths"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		signed = append(signed, body.Paths...)
+		var response []map[string]string
+		for _, path := range body.Paths {
+			response = append(response, map[string]string{"path": path, "signedURL": "/object/sign/test1/" + path + "?token=t"})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	urls, err := c.CreateSignedUrlsForPrefix("test1", "/gallery/", 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(signed) != "[gallery/a.png gallery/2024/b.png]" {
+		t.Errorf("expected every object under the prefix to be signed, got %v", signed)
+	}
+	if len(urls) != 2 || urls[1].Path != "gallery/2024/b.png" || urls[1].SignedURL != server.URL+"/object/sign/test1/gallery/2024/b.png?token=t" {
+		t.Errorf("unexpected signed URLs %v", urls)
+	}
+
+	if _, err := c.CreateSignedUrlsForPrefix("test1", "gallery", 0); err == nil {
+		t.Error("expected a zero expiry to be rejected")
+	}
+}
+
+func TestUploadContentDisposition(t *testing.T) {
+	var disposition, field string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		disposition = r.Header.Get("Content-Disposition")
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			field = r.FormValue("contentDisposition")
+		}
+		w.Write([]byte(`{"Key":"test1/7f3a.pdf"}`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	for _, multipart := range []bool{false, true} {
+		disposition = ""
+		_, err := c.UploadFileWithOptions("test1", "7f3a.pdf", strings.NewReader("%PDF"), storage_go.FileOptions{
+			ContentDisposition: `attachment; filename="Q3 report.pdf"`,
+			UseMultipart:       multipart,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if disposition != `attachment; filename="Q3 report.pdf"` {
+			t.Errorf("multipart %v: unexpected content disposition %q", multipart, disposition)
+		}
+	}
+	if field != `attachment; filename="Q3 report.pdf"` {
+		t.Errorf("unexpected contentDisposition form field %q", field)
+	}
+
+	disposition = ""
+	if _, err := c.UploadFile("test1", "7f3a.pdf", strings.NewReader("%PDF")); err != nil {
+		t.Fatal(err)
+	}
+	if disposition != "" {
+		t.Errorf("expected no content disposition by default, got %q", disposition)
+	}
+}
+
+func TestUploadSniffedContentLength(t *testing.T) {
+	var received []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, len(body))
+		if len(received) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"Key":"test1/data.bin"}`))
+	}))
+	defer server.Close()
+
+	// Sniffing reads ahead of the size and replay offset, they have to be taken before
+	data := bytes.Repeat([]byte("a"), 2048)
+	c := storage_go.NewClient(server.URL, token, map[string]string{}, storage_go.WithRetry(1, time.Millisecond))
+	_, err := c.UpdateFileWithOptions("test1", "data.unknownext", bytes.NewReader(data), storage_go.FileOptions{DetectContentType: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(received) != "[2048 2048]" {
+		t.Errorf("expected the whole data on every attempt, got %v bytes", received)
+	}
 }
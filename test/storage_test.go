@@ -1,37 +1,181 @@
 package test
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/supabase-community/storage-go"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 )
 
 func TestBucketListAll(t *testing.T) {
 	c := storage_go.NewClient("https://abc.supabase.co/storage/v1", "", map[string]string{})
-	c.ListBuckets()
+	fmt.Println(c.ListBuckets())
 }
 
 func TestBucketFetchById(t *testing.T) {
 	c := storage_go.NewClient("https://abc.supabase.co/storage/v1", "", map[string]string{})
-	fmt.Println(c.GetBucket("shield"))
+	bucket, err := c.GetBucket("shield")
+	fmt.Println(bucket, err)
 }
 
 func TestBucketCreate(t *testing.T) {
 	c := storage_go.NewClient("https://abc.supabase.co/storage/v1", "", map[string]string{})
-	fmt.Println(c.CreateBucket("test1", storage_go.BucketOptions{
+	bucket, err := c.CreateBucket("test1", storage_go.BucketOptions{
 		Public: true,
-	}))
+	})
+	fmt.Println(bucket, err)
 }
 
 func TestBucketUpdate(t *testing.T) {
 	c := storage_go.NewClient("https://abc.supabase.co/storage/v1", "", map[string]string{})
-	c.UpdateBucket("test1", storage_go.BucketOptions{
+	bucket, err := c.UpdateBucket("test1", storage_go.BucketOptions{
 		Public: false,
 	})
-
-	bucket, _ := c.GetBucket("test1")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 
 	if bucket.Public {
 		t.Errorf("Should have been private bucket after updating")
 	}
 }
+
+func TestBucketEmpty(t *testing.T) {
+	c := storage_go.NewClient("https://abc.supabase.co/storage/v1", "", map[string]string{})
+	fmt.Println(c.EmptyBucket("test1"))
+}
+
+func TestBucketDelete(t *testing.T) {
+	c := storage_go.NewClient("https://abc.supabase.co/storage/v1", "", map[string]string{})
+	fmt.Println(c.DeleteBucket("test1"))
+}
+
+func TestBucketDeleteReusesConnections(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A body the client never reads, large enough not to be consumed along with the headers,
+		// it has to be drained for the connection to be reused
+		fmt.Fprintf(w, `{"message":"Successfully deleted","padding":"%s"}`, strings.Repeat("x", 32<<10))
+	}))
+	var mu sync.Mutex
+	connections := 0
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			connections++
+			mu.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	for i := 0; i < 5; i++ {
+		if err := c.DeleteBucket("test1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if connections != 1 {
+		t.Errorf("expected a single reused connection, got %d", connections)
+	}
+}
+
+func TestBucketListWithPrefix(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id":"tenant_1_avatars","name":"tenant_1_avatars"},{"id":"tenant_2_avatars","name":"tenant_2_avatars","public":true},{"id":"tenant_1_docs","name":"tenant_1_docs"}]`))
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	buckets, err := c.ListBucketsWithPrefix("tenant_1_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 2 || buckets[0].Id != "tenant_1_avatars" || buckets[1].Id != "tenant_1_docs" {
+		t.Errorf("expected the tenant_1_ buckets, got %v", buckets)
+	}
+
+	public, err := c.ListBucketsFiltered(func(bucket storage_go.Bucket) bool { return bucket.Public })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(public) != 1 || public[0].Id != "tenant_2_avatars" {
+		t.Errorf("expected the public bucket, got %v", public)
+	}
+}
+
+func TestBucketClient(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == http.MethodGet:
+			w.Write([]byte("hello"))
+		case r.URL.Path == "/object/list/avatars":
+			w.Write([]byte(`[{"name":"a.png","id":"1"}]`))
+		case r.Method == http.MethodDelete:
+			w.Write([]byte(`[{"name":"users/a.png"}]`))
+		default:
+			w.Write([]byte(`{"Key":"avatars/users/a.png"}`))
+		}
+	}))
+	defer server.Close()
+
+	bucket := storage_go.NewClient(server.URL, token, map[string]string{}).Bucket("avatars")
+	if bucket.Id() != "avatars" {
+		t.Errorf("expected the bucket ID, got %q", bucket.Id())
+	}
+	if _, err := bucket.Upload("users/a.png", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := bucket.Download("users/a.png"); err != nil || string(data) != "hello" {
+		t.Errorf("unexpected download %q, %v", data, err)
+	}
+	if files, err := bucket.List("users", storage_go.FileSearchOptions{}); err != nil || len(files) != 1 {
+		t.Errorf("unexpected listing %v, %v", files, err)
+	}
+	if removed, err := bucket.Remove([]string{"users/a.png"}); err != nil || len(removed) != 1 {
+		t.Errorf("unexpected removal %v, %v", removed, err)
+	}
+	if url := bucket.GetPublicUrl("users/a.png").SignedURL; url != server.URL+"/object/public/avatars/users/a.png" {
+		t.Errorf("unexpected public URL %s", url)
+	}
+
+	expected := "[POST /object/avatars/users/a.png GET /object/authenticated/avatars/users/a.png POST /object/list/avatars DELETE /object/avatars]"
+	if fmt.Sprint(requests) != expected {
+		t.Errorf("expected %s, got %s", expected, requests)
+	}
+}
+
+func TestBucketUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body storage_go.ListFileRequestBody
+		json.NewDecoder(r.Body).Decode(&body)
+		switch body.Prefix {
+		case "":
+			w.Write([]byte(`[{"name":"a.txt","id":"1","metadata":{"size":100}},{"name":"users","id":null}]`))
+		case "users":
+			w.Write([]byte(`[{"name":"b.png","id":"2","metadata":{"size":2048}},{"name":"c.png","id":"3","metadata":{"size":4096}}]`))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	c := storage_go.NewClient(server.URL, token, map[string]string{})
+	usage, err := c.GetBucketUsage("test1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.ObjectCount != 3 || usage.TotalSize != 6244 {
+		t.Errorf("expected 3 objects of 6244 bytes, got %+v", usage)
+	}
+}
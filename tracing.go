@@ -0,0 +1,108 @@
+package storage_go
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/supabase-community/storage-go"
+
+// WithTracerProvider records a span named after the operation, e.g. "storage.upload", around every
+// storage request, retries included, and propagates the trace context with the global propagator.
+// The span ends once the response body is read or closed. Without it requests aren't traced.
+func WithTracerProvider(tp trace.TracerProvider) ClientOption {
+	return func(c *Client) {
+		c.tracer = tp.Tracer(tracerName, trace.WithInstrumentationVersion(version))
+	}
+}
+
+// doWithSpan is doWithTimeout wrapped in a span of the request operation
+func (c *Client) doWithSpan(request *http.Request) (*http.Response, error) {
+	// The query is left out since it can carry signed URL tokens
+	requestUrl := c.clientTransport.baseUrl.ResolveReference(request.URL)
+	requestUrl.RawQuery = ""
+	apiPath := strings.TrimPrefix(request.URL.Path, strings.TrimSuffix(c.clientTransport.baseUrl.Path, "/"))
+
+	ctx, span := c.tracer.Start(request.Context(), spanName(request.Method, apiPath), trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", request.Method),
+			attribute.String("http.url", requestUrl.String()),
+		))
+	request = request.WithContext(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(request.Header))
+
+	res, err := c.doWithTimeout(request)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("http.status_code", res.StatusCode))
+	if !isSuccess(res) {
+		span.SetStatus(codes.Error, http.StatusText(res.StatusCode))
+	}
+	res.Body = &spanBody{ReadCloser: res.Body, span: span}
+
+	return res, nil
+}
+
+// spanBody ends the span once the response body is fully read or closed, whichever comes first
+type spanBody struct {
+	io.ReadCloser
+	span trace.Span
+	once sync.Once
+}
+
+func (b *spanBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(func() { b.span.End() })
+	}
+	return n, err
+}
+
+func (b *spanBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.span.End() })
+	return err
+}
+
+// spanName names the storage operation of a request after its API path, relative to the base URL
+func spanName(method string, path string) string {
+	switch {
+	case strings.HasPrefix(path, "/bucket"):
+		return "storage.bucket"
+	case strings.HasPrefix(path, "/upload/resumable"):
+		return "storage.upload.resumable"
+	case strings.HasPrefix(path, "/render/image/"):
+		return "storage.transform"
+	case strings.HasPrefix(path, "/object/list/"):
+		return "storage.list"
+	case strings.HasPrefix(path, "/object/upload/sign/") && method != http.MethodPost:
+		return "storage.upload"
+	case strings.HasPrefix(path, "/object/sign/"), strings.HasPrefix(path, "/object/upload/sign/"):
+		return "storage.sign"
+	case strings.HasPrefix(path, "/object/move"):
+		return "storage.move"
+	case strings.HasPrefix(path, "/object/copy"):
+		return "storage.copy"
+	case strings.HasPrefix(path, "/object/info/"):
+		return "storage.info"
+	case method == http.MethodGet || method == http.MethodHead:
+		return "storage.download"
+	case method == http.MethodDelete:
+		return "storage.remove"
+	}
+
+	return "storage.upload"
+}